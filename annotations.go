@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"env-deployment-k8s/pkg/injector"
+	"gopkg.in/yaml.v3"
+)
+
+// checksumAnnotation is the pod template annotation holding the checksum of the injected Secrets.
+// Any change to a Secret changes the pod template, so the workload rolls its pods.
+const checksumAnnotation = "checksum/secret"
+
+// secretChecksum returns the SHA-256 of the Secrets' names, keys and values, independent of
+// the order keys are declared in
+func secretChecksum(secrets []Secret) string {
+	hash := sha256.New()
+	for i := range secrets {
+		name, _ := injector.MetadataString(secrets[i].Metadata, "name")
+		fmt.Fprintf(hash, "secret %s\n", name)
+
+		keys := injector.SecretKeys(&secrets[i])
+		sort.Strings(keys)
+		for _, key := range keys {
+			value, ok := secrets[i].Data[key]
+			if !ok {
+				value = secrets[i].StringData[key]
+			}
+			fmt.Fprintf(hash, "%s=%s\n", key, value)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// setAnnotation stores an annotation in the pod template's metadata, creating the maps as needed
+func setAnnotation(template *PodTemplate, key, value string) {
+	if template.Metadata == nil {
+		template.Metadata = make(map[string]interface{})
+	}
+	setMetadataAnnotation(template.Metadata, key, value)
+}
+
+// setMetadataAnnotation stores an annotation in an object's metadata, adding the annotations map when
+// there is none and keeping the annotations already there
+func setMetadataAnnotation(metadata map[string]interface{}, key, value string) {
+	annotations, ok := metadata["annotations"].(map[string]interface{})
+	if !ok {
+		annotations = make(map[string]interface{})
+		metadata["annotations"] = annotations
+	}
+	annotations[key] = value
+}
+
+// annotateWorkload merges the annotations into the workload's metadata, overwriting the ones
+// with the same key and keeping the others
+func annotateWorkload(source SourceWorkload, annotations map[string]string) {
+	if len(annotations) == 0 {
+		return
+	}
+	metadata := source.Workload.ObjectMeta()
+	if metadata == nil {
+		warnf("%s in file %s has no metadata: not annotating it\n", source.Kind, source.File)
+		return
+	}
+	for key, value := range annotations {
+		setMetadataAnnotation(metadata, key, value)
+	}
+}
+
+// writeAnnotations copies the string annotations of an object's metadata, or a pod template's, into the
+// document node holding it, touching only the ones that are missing or differ so the rest of the metadata
+// is left as it was
+func writeAnnotations(objectNode *yaml.Node, metadata map[string]interface{}) error {
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	return writeStrings(objectNode, annotations, "metadata", "annotations")
+}
+
+// writeStrings copies the string values of a map, such as annotations or labels, into the mapping at path
+// below node, touching only the ones that are missing or differ
+func writeStrings(node *yaml.Node, values map[string]interface{}, path ...string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, ok := values[key].(string)
+		if !ok {
+			continue
+		}
+		if current := lookupNode(node, append(path, key)...); current != nil && current.Value == value {
+			continue
+		}
+		mappingNode, err := mappingChild(node, path...)
+		if err != nil {
+			return err
+		}
+		err = setNodeValue(mappingNode, key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
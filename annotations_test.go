@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSecretChecksum(t *testing.T) {
+	secret := func(keys []string, data map[string]string) []Secret {
+		return []Secret{{Metadata: map[string]interface{}{"name": "app-secret"}, Data: data, Keys: keys}}
+	}
+
+	base := secretChecksum(secret([]string{"a", "b"}, map[string]string{"a": "MQ==", "b": "Mg=="}))
+	if got := secretChecksum(secret([]string{"b", "a"}, map[string]string{"a": "MQ==", "b": "Mg=="})); got != base {
+		t.Errorf("checksum changed with the declared key order: %s != %s", got, base)
+	}
+	if got := secretChecksum(secret([]string{"a", "b"}, map[string]string{"a": "MQ==", "b": "Mw=="})); got == base {
+		t.Errorf("checksum did not change with a value")
+	}
+}
+
+func TestMarshalWorkloadAnnotation(t *testing.T) {
+	var node yaml.Node
+	input := "apiVersion: apps/v1\nkind: Deployment\nspec:\n  template:\n    metadata:\n      labels:\n        app: api\n    spec:\n      containers:\n        - name: app\n"
+	if err := yaml.Unmarshal([]byte(input), &node); err != nil {
+		t.Fatal(err)
+	}
+	var dep Deployment
+	if err := node.Decode(&dep); err != nil {
+		t.Fatal(err)
+	}
+
+	setAnnotation(dep.Template(), checksumAnnotation, "abc123")
+	out, err := marshalWorkload(SourceWorkload{Kind: "Deployment", Workload: &dep, Node: &node}, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "    metadata:\n      labels:\n        app: api\n      annotations:\n        checksum/secret: abc123\n"
+	if !strings.Contains(string(out), want) {
+		t.Errorf("output does not contain the annotation under the pod template metadata:\n%s", out)
+	}
+}
+
+func TestAnnotateWorkload(t *testing.T) {
+	var node yaml.Node
+	input := "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\n  annotations:\n    team: payments\n    managed-by: helm\nspec:\n  template:\n    spec:\n      containers:\n        - name: app\n"
+	if err := yaml.Unmarshal([]byte(input), &node); err != nil {
+		t.Fatal(err)
+	}
+	var dep Deployment
+	if err := node.Decode(&dep); err != nil {
+		t.Fatal(err)
+	}
+
+	source := SourceWorkload{Kind: "Deployment", Workload: &dep, Node: &node}
+	annotateWorkload(source, map[string]string{"managed-by": "env-injector", "injected-at": "2024-05-01"})
+	out, err := marshalWorkload(source, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "metadata:\n  name: api\n  annotations:\n    team: payments\n    managed-by: env-injector\n    injected-at: \"2024-05-01\"\n"
+	if !strings.Contains(string(out), want) {
+		t.Errorf("output does not contain the merged annotations:\n%s", out)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// backupFile copies a source file to <file>.bak, or to the first free <file>.bak.N when
+// earlier backups exist, and returns the backup path
+func backupFile(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+
+	backup, err := backupPath(file)
+	if err != nil {
+		return "", err
+	}
+	err = writeFileAtomic(backup, data, info.Mode().Perm())
+	if err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// backupPath returns the first of <file>.bak, <file>.bak.1, <file>.bak.2, ... that doesn't exist yet
+func backupPath(file string) (string, error) {
+	candidate := file + ".bak"
+	for i := 1; ; i++ {
+		_, err := os.Stat(candidate)
+		if os.IsNotExist(err) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s.bak.%d", file, i)
+	}
+}
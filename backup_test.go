@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "deployment.yaml")
+	if err := os.WriteFile(file, []byte("kind: Deployment\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{file + ".bak", file + ".bak.1", file + ".bak.2"} {
+		got, err := backupFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("backupFile() = %s, want %s", got, want)
+		}
+		data, err := os.ReadFile(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "kind: Deployment\n" {
+			t.Errorf("backup %s holds %q, want the original contents", got, data)
+		}
+	}
+}
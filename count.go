@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// kindCount is the tally of one kind for -count: the Secrets and ConfigMaps found to inject from, the
+// workloads processed, and the resources skipped for any reason, including kinds that aren't supported
+type kindCount struct {
+	Kind      string
+	Found     int
+	Processed int
+	Skipped   int
+}
+
+// countKinds tallies the resources of the run by kind. A workload handed over for processing counts as
+// processed unless it was skipped along the way, such as for having no Secret in its namespace.
+func countKinds(manifests *Manifests, workloads []SourceWorkload, fails *failures) []kindCount {
+	counts := make(map[string]*kindCount)
+	count := func(kind string) *kindCount {
+		if kind == "" {
+			kind = "(unknown kind)"
+		}
+		if counts[kind] == nil {
+			counts[kind] = &kindCount{Kind: kind}
+		}
+		return counts[kind]
+	}
+
+	count("Secret").Found = len(manifests.Secrets)
+	count("ConfigMap").Found = len(manifests.ConfigMaps)
+
+	fails.mu.Lock()
+	skipped := make(map[string]bool, len(fails.skipped))
+	for _, skip := range fails.skipped {
+		count(skip.Kind).Skipped++
+		skipped[skip.File+"/"+skip.Kind+"/"+skip.Name] = true
+	}
+	fails.mu.Unlock()
+
+	for _, source := range workloads {
+		if !skipped[source.File+"/"+source.Kind+"/"+workloadName(source)] {
+			count(source.Kind).Processed++
+		}
+	}
+
+	result := make([]kindCount, 0, len(counts))
+	for _, c := range counts {
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Kind < result[j].Kind })
+	return result
+}
+
+// printCounts lists the tally of each kind, with the kinds lined up in a column
+func printCounts(w io.Writer, counts []kindCount) {
+	fmt.Fprintln(w, "Resources by kind:")
+
+	width := 0
+	for _, c := range counts {
+		if len(c.Kind) > width {
+			width = len(c.Kind)
+		}
+	}
+	for _, c := range counts {
+		fmt.Fprintf(w, "  %-*s  %s\n", width+1, c.Kind+":", c.describe())
+	}
+}
+
+// logCounts is printCounts for -log-format json: an entry for each kind, with the kind as a field
+func logCounts(counts []kindCount) {
+	for _, c := range counts {
+		logf("info", "", logFields{Kind: c.Kind}, "%s\n", c.describe())
+	}
+}
+
+// describe puts the tally in words: the Secrets and ConfigMaps found or the workloads processed,
+// then how many were skipped
+func (c kindCount) describe() string {
+	var parts []string
+	_, supported := supportedAPIVersions[c.Kind]
+	switch {
+	case c.Kind == "Secret" || c.Kind == "ConfigMap":
+		parts = append(parts, fmt.Sprintf("%d found", c.Found))
+	case supported:
+		parts = append(parts, fmt.Sprintf("%d processed", c.Processed))
+	}
+	if c.Skipped > 0 {
+		parts = append(parts, fmt.Sprintf("%d skipped", c.Skipped))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// reportCounts prints the -count tally at the end of the run, as JSON log entries with -log-format json,
+// unless -quiet
+func reportCounts(manifests *Manifests, workloads []SourceWorkload, opts *options, fails *failures) {
+	if !opts.count || opts.quiet {
+		return
+	}
+	counts := countKinds(manifests, workloads, fails)
+	if jsonLogs {
+		logCounts(counts)
+		return
+	}
+	printCounts(os.Stderr, counts)
+}
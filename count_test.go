@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCountKinds(t *testing.T) {
+	api := SourceWorkload{Kind: "Deployment", File: "a.yaml", Workload: &Deployment{Metadata: map[string]interface{}{"name": "api"}}}
+	worker := SourceWorkload{Kind: "Deployment", File: "a.yaml", Workload: &Deployment{Metadata: map[string]interface{}{"name": "worker"}}}
+	manifests := &Manifests{Secrets: []Secret{{}}, Workloads: []SourceWorkload{api, worker}}
+
+	fails := newFailures(false)
+	fails.skip("a.yaml", "Service", "svc", "document 2 is not a supported kind")
+	fails.skip("a.yaml", "Deployment", "worker", "no Secret or ConfigMap in namespace prod")
+
+	got := countKinds(manifests, []SourceWorkload{api, worker}, fails)
+	want := []kindCount{
+		{Kind: "ConfigMap"},
+		{Kind: "Deployment", Processed: 1, Skipped: 1},
+		{Kind: "Secret", Found: 1},
+		{Kind: "Service", Skipped: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("countKinds() = %+v, want %+v", got, want)
+	}
+
+	var buf bytes.Buffer
+	printCounts(&buf, got)
+	wantOut := "Resources by kind:\n" +
+		"  ConfigMap:   0 found\n" +
+		"  Deployment:  1 processed, 1 skipped\n" +
+		"  Secret:      1 found\n" +
+		"  Service:     1 skipped\n"
+	if buf.String() != wantOut {
+		t.Errorf("printCounts() =\n%s\nwant\n%s", buf.String(), wantOut)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// parseExtensions turns a comma-separated extension list into a lowercase set, with or without leading dots
+func parseExtensions(list string) map[string]bool {
+	extensions := make(map[string]bool)
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			extensions["."+ext] = true
+		}
+	}
+	return extensions
+}
+
+// hasExtension reports whether the path ends in one of the extensions, ignoring case
+func hasExtension(path string, extensions map[string]bool) bool {
+	return extensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// isManifestFile reports whether the path has one of the extensions, possibly followed by .gz
+func isManifestFile(path string, extensions map[string]bool) bool {
+	return hasExtension(strings.TrimSuffix(strings.ToLower(path), gzipExtension), extensions)
+}
+
+// findYAMLFiles lists the files in dir with one of the extensions, gzip-compressed or not, walking its subdirectories
+// when recursive is set. Symlinked directories are not followed to avoid loops.
+func findYAMLFiles(dir string, recursive bool, extensions map[string]bool) ([]string, error) {
+	var files []string
+
+	if !recursive {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && isManifestFile(entry.Name(), extensions) {
+				files = append(files, filepath.Join(dir, entry.Name()))
+			}
+		}
+		return files, nil
+	}
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && isManifestFile(path, extensions) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// readFileList reads a -files list: one manifest path per line, in the order to process them.
+// Blank lines and lines starting with # are skipped, and relative paths are resolved from the
+// list's own directory. Every listed file must exist.
+func readFileList(list string) ([]string, error) {
+	data, err := os.ReadFile(list)
+	if err != nil {
+		return nil, err
+	}
+
+	var files, missing []string
+	for _, line := range strings.Split(string(normalizeInput(data)), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		file := line
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(list), file)
+		}
+		if _, err := os.Stat(file); err != nil {
+			missing = append(missing, line)
+			continue
+		}
+		files = append(files, file)
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("listed files not found: %s", strings.Join(missing, ", "))
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files listed")
+	}
+	return files, nil
+}
+
+// excludeFiles drops the files whose base name matches one of the -exclude glob patterns
+func excludeFiles(files []string, patterns []string) []string {
+	if len(patterns) == 0 {
+		return files
+	}
+
+	var kept []string
+	for _, file := range files {
+		if matchesAny(filepath.Base(file), patterns) {
+			debugf("Excluding file: %s\n", file)
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept
+}
+
+// matchesAny reports whether name matches one of the glob patterns. The patterns are checked
+// at startup, so a malformed one can't show up here.
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// sameFileContent reports whether the file at path already holds exactly data
+func sameFileContent(path string, data []byte) bool {
+	existing, err := os.ReadFile(path)
+	return err == nil && bytes.Equal(existing, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place, so anyone
+// watching the directory only ever sees the old file or the complete new one
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Clean up the temporary file unless it was renamed into place
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExcludeFiles(t *testing.T) {
+	files := []string{"manifests/app.yaml", "manifests/kustomization.yaml", "manifests/values-prod.yaml", "manifests/base/secret.yaml"}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{"no patterns", nil, files},
+		{"exact name", []string{"kustomization.yaml"}, []string{"manifests/app.yaml", "manifests/values-prod.yaml", "manifests/base/secret.yaml"}},
+		{"glob", []string{"values*.yaml", "kustomization.yaml"}, []string{"manifests/app.yaml", "manifests/base/secret.yaml"}},
+		{"base name only", []string{"base/*"}, files},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := excludeFiles(files, tt.patterns)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("excludeFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app_updated.yaml")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(path, []byte("new\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new\n" {
+		t.Errorf("file holds %q, want %q", data, "new\n")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("file mode = %v, want 0640", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the written file", len(entries))
+	}
+}
+
+func TestReadFileList(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"secret.yaml", "app.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("kind: Secret\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(list, []byte("# ordered manifests\nsecret.yaml\n\n  app.yaml\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readFileList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "secret.yaml"), filepath.Join(dir, "app.yaml")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readFileList() = %v, want %v", got, want)
+	}
+
+	if err := os.WriteFile(list, []byte("app.yaml\nmissing.yaml\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readFileList(list); err == nil || !strings.Contains(err.Error(), "missing.yaml") {
+		t.Errorf("readFileList() error = %v, want one naming missing.yaml", err)
+	}
+}
+
+func TestSameFileContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app_updated.yaml")
+	if sameFileContent(path, []byte("kind: Deployment\n")) {
+		t.Error("sameFileContent() = true for a missing file")
+	}
+	if err := os.WriteFile(path, []byte("kind: Deployment\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !sameFileContent(path, []byte("kind: Deployment\n")) {
+		t.Error("sameFileContent() = false for identical content")
+	}
+	if sameFileContent(path, []byte("kind: StatefulSet\n")) {
+		t.Error("sameFileContent() = true for different content")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"env-deployment-k8s/pkg/injector"
+	"gopkg.in/yaml.v3"
+)
+
+// secretFromEnv builds a Secret holding the base64 encoded values of the process env vars named
+// after the keys. A key is looked up as written, then uppercased, so db_url can come from DB_URL.
+func secretFromEnv(name, namespace string, keys []string) (Secret, error) {
+	metadata := map[string]interface{}{"name": name}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	secret := Secret{APIVersion: "v1", Kind: "Secret", Metadata: metadata, Data: make(map[string]string)}
+
+	for _, key := range keys {
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			value, ok = os.LookupEnv(strings.ToUpper(key))
+		}
+		if !ok {
+			return Secret{}, fmt.Errorf("env var %s is not set", key)
+		}
+		if _, ok := secret.Data[key]; !ok {
+			secret.Keys = append(secret.Keys, key)
+		}
+		secret.Data[key] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	return secret, nil
+}
+
+// secretFromEnvFile builds a Secret holding the base64 encoded values of a dotenv file's KEY=VALUE lines.
+// Blank lines and # comments are ignored, and a line may start with export as in a shell script.
+func secretFromEnvFile(name, namespace, file string) (Secret, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Secret{}, err
+	}
+	metadata := map[string]interface{}{"name": name}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	secret := Secret{APIVersion: "v1", Kind: "Secret", Metadata: metadata, Data: make(map[string]string)}
+
+	for i, line := range strings.Split(string(normalizeInput(data)), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return Secret{}, fmt.Errorf("line %d: expected KEY=VALUE", i+1)
+		}
+		value, err := envFileValue(strings.TrimSpace(value))
+		if err != nil {
+			return Secret{}, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if _, ok := secret.Data[key]; !ok {
+			secret.Keys = append(secret.Keys, key)
+		}
+		secret.Data[key] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	return secret, nil
+}
+
+// envFileValue unquotes a dotenv value. Double quotes allow escapes such as \n, single quotes keep the
+// value as written, and an unquoted value ends at a # comment preceded by a space.
+func envFileValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	switch quote := value[0]; quote {
+	case '"', '\'':
+		end := strings.LastIndexByte(value, quote)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated %c quoted value", quote)
+		}
+		if rest := strings.TrimSpace(value[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after the quoted value", rest)
+		}
+		if quote == '\'' {
+			return value[1:end], nil
+		}
+		unquoted, err := strconv.Unquote(value[:end+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid double quoted value: %v", err)
+		}
+		return unquoted, nil
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
+
+// writeSecret saves a generated Secret as a manifest, readable only by the owner since it holds the values
+func writeSecret(path string, secret Secret) error {
+	data, err := marshalSecret(secret)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// marshalSecret encodes a Secret with its data keys in declared order
+func marshalSecret(secret Secret) ([]byte, error) {
+	document := struct {
+		APIVersion string                 `yaml:"apiVersion"`
+		Kind       string                 `yaml:"kind"`
+		Metadata   map[string]interface{} `yaml:"metadata"`
+		Type       string                 `yaml:"type"`
+	}{secret.APIVersion, secret.Kind, secret.Metadata, "Opaque"}
+
+	var node yaml.Node
+	err := node.Encode(document)
+	if err != nil {
+		return nil, err
+	}
+	dataNode, err := mappingChild(&node, "data")
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range injector.SecretKeys(&secret) {
+		err = setNodeValue(dataNode, key, secret.Data[key])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return marshalNode(&node)
+}
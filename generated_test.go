@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"env-deployment-k8s/pkg/injector"
+)
+
+func TestSecretFromEnv(t *testing.T) {
+	t.Setenv("DB_URL", "postgres://db")
+	t.Setenv("api_key", "key")
+
+	secret, err := secretFromEnv("ci-secret", "staging", []string{"db_url", "api_key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"db_url": "cG9zdGdyZXM6Ly9kYg==", "api_key": "a2V5"}
+	if !reflect.DeepEqual(secret.Data, want) {
+		t.Errorf("Data = %v, want %v", secret.Data, want)
+	}
+	if got := namespaceOf(secret.Metadata); got != "staging" {
+		t.Errorf("namespace = %s, want staging", got)
+	}
+
+	if _, err := secretFromEnv("ci-secret", "", []string{"not_set_anywhere"}); err == nil {
+		t.Error("secretFromEnv() expected an error for a missing env var")
+	}
+}
+
+func TestSecretFromEnvFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".env")
+	data := `# local development
+DB_URL=postgres://db
+
+export API_KEY = "line one\nline two"
+GREETING='hello # not a comment'
+PORT=8080 # the app's port
+EMPTY=
+`
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := secretFromEnvFile("local-secret", "dev", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"DB_URL":   base64.StdEncoding.EncodeToString([]byte("postgres://db")),
+		"API_KEY":  base64.StdEncoding.EncodeToString([]byte("line one\nline two")),
+		"GREETING": base64.StdEncoding.EncodeToString([]byte("hello # not a comment")),
+		"PORT":     base64.StdEncoding.EncodeToString([]byte("8080")),
+		"EMPTY":    "",
+	}
+	if !reflect.DeepEqual(secret.Data, want) {
+		t.Errorf("Data = %v, want %v", secret.Data, want)
+	}
+	if wantKeys := []string{"DB_URL", "API_KEY", "GREETING", "PORT", "EMPTY"}; !reflect.DeepEqual(secret.Keys, wantKeys) {
+		t.Errorf("Keys = %v, want %v", secret.Keys, wantKeys)
+	}
+	if got, _ := injector.MetadataString(secret.Metadata, "name"); got != "local-secret" {
+		t.Errorf("name = %s, want local-secret", got)
+	}
+}
+
+func TestSecretFromEnvFileInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"no equals sign", "DB_URL\n"},
+		{"no key", "=value\n"},
+		{"unterminated quote", "API_KEY=\"key\n"},
+		{"text after quote", "API_KEY='key' extra\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), ".env")
+			if err := os.WriteFile(file, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := secretFromEnvFile("local-secret", "", file); err == nil {
+				t.Errorf("secretFromEnvFile() error = nil for %q", tt.data)
+			}
+		})
+	}
+}
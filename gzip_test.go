@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecompress(t *testing.T) {
+	plain := []byte("apiVersion: v1\nkind: Secret\n")
+	packed, err := compress(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, compressed, err := decompress(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compressed || !bytes.Equal(got, plain) {
+		t.Errorf("decompress() = (%q, %v), want (%q, true)", got, compressed, plain)
+	}
+
+	got, compressed, err = decompress(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compressed || !bytes.Equal(got, plain) {
+		t.Errorf("decompress() of plain data = (%q, %v), want it unchanged", got, compressed)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"env-deployment-k8s/pkg/injector"
+)
+
+// envInjector injects Secrets and ConfigMaps into workloads. A workload receives the ones from its
+// own namespace: with -secret-pattern, only the Secret named after it when one exists, or otherwise
+// the shared Secrets that don't belong to any workload. Env vars are built once per set of sources.
+type envInjector struct {
+	manifests  *Manifests
+	opts       *options
+	namespaces map[string]*namespaceSources
+	dedicated  map[string]bool
+	envVars    map[string][]EnvVar
+	envFrom    map[string][]EnvFromSource
+	checksums  map[string]string
+	envOpts    injector.Options
+}
+
+// namespaceSources are the Secrets and ConfigMaps workloads in one namespace may reference, and the
+// names of the Secrets holding registry credentials for their imagePullSecrets
+type namespaceSources struct {
+	secrets     []Secret
+	configMaps  []ConfigMap
+	pullSecrets []string
+}
+
+func newEnvInjector(manifests *Manifests, opts *options, fails *failures) *envInjector {
+	inj := &envInjector{
+		manifests:  manifests,
+		opts:       opts,
+		namespaces: make(map[string]*namespaceSources),
+		dedicated:  make(map[string]bool),
+		envVars:    make(map[string][]EnvVar),
+		envFrom:    make(map[string][]EnvFromSource),
+		checksums:  make(map[string]string),
+		envOpts:    opts.injectorOptions(fails),
+	}
+
+	// Secrets matching the pattern for some workload are that workload's own, not shared ones
+	if opts.secretPattern != "" {
+		for _, source := range manifests.Workloads {
+			inj.dedicated[secretNameFor(opts.secretPattern, workloadName(source))] = true
+		}
+	}
+
+	// So are the Secrets of the -secret-for rules, when their files are among the inputs too
+	for _, rule := range opts.secretFor {
+		inj.dedicated[objectName(rule.Secret.Metadata)] = true
+	}
+
+	return inj
+}
+
+// inject assigns the env vars, or envFrom references, for the workload to its containers, and
+// references the registry credentials of its namespace from imagePullSecrets.
+// It reports false when there is no Secret, ConfigMap or literal -env var to inject.
+func (inj *envInjector) inject(source SourceWorkload) ([]injector.InjectedContainer, bool) {
+	key, secrets, configMaps := inj.sourcesFor(source)
+	pullSecrets := inj.sourcesIn(namespaceOf(source.Workload.ObjectMeta())).pullSecrets
+	for _, name := range pullSecrets {
+		if injector.AddImagePullSecret(source.Workload.PodSpec(), name) {
+			infof("Added image pull Secret %s to %s %s\n", name, source.Kind, workloadName(source))
+		}
+	}
+	if len(secrets) == 0 && len(configMaps) == 0 && len(inj.opts.literalEnv) == 0 {
+		return nil, len(pullSecrets) > 0
+	}
+
+	// Stamp the checksum of the Secrets so the pods roll whenever one of them changes
+	if inj.opts.checksumAnnotation && len(secrets) > 0 {
+		checksum, ok := inj.checksums[key]
+		if !ok {
+			checksum = secretChecksum(secrets)
+			inj.checksums[key] = checksum
+		}
+		setAnnotation(source.Workload.Template(), checksumAnnotation, checksum)
+	}
+
+	if inj.opts.envFrom {
+		envFrom, ok := inj.envFrom[key]
+		if !ok {
+			envFrom = injector.BuildEnvFromSources(secrets, configMaps, inj.envOpts)
+			inj.envFrom[key] = envFrom
+		}
+		return injector.InjectEnvFrom(source.Workload, envFrom, inj.envOpts.Merge, inj.envOpts.Containers), true
+	}
+
+	envVars, ok := inj.envVars[key]
+	if !ok {
+		var err error
+		envVars, err = injector.BuildEnvVars(secrets, configMaps, inj.envOpts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		inj.envVars[key] = envVars
+	}
+	return injector.InjectEnv(source.Workload, envVars, inj.envOpts.Merge, inj.envOpts.Containers), true
+}
+
+// check builds the env vars for a workload without injecting them, so -check reports the name collisions
+// and invalid names a run would. Like inject it builds them once per set of sources.
+func (inj *envInjector) check(source SourceWorkload, fails *failures) {
+	key, secrets, configMaps := inj.sourcesFor(source)
+	if len(secrets) == 0 && len(configMaps) == 0 && len(inj.opts.literalEnv) == 0 {
+		if len(inj.sourcesIn(namespaceOf(source.Workload.ObjectMeta())).pullSecrets) == 0 {
+			inj.skipEmpty(source, fails)
+		}
+		return
+	}
+	// envFrom names no env vars, so there is nothing more to check
+	if inj.opts.envFrom {
+		return
+	}
+	if _, ok := inj.envVars[key]; ok {
+		return
+	}
+	envVars, err := injector.BuildEnvVars(secrets, configMaps, inj.envOpts)
+	inj.envVars[key] = envVars
+	if err != nil {
+		fails.note("%s %s in file %s: %v\n", source.Kind, workloadName(source), source.File, err)
+	}
+}
+
+// skipEmpty reports a workload that has nothing to inject in its own namespace. When other namespaces
+// hold Secrets or ConfigMaps, it is refused as an error: Kubernetes rejects references across namespaces,
+// so it is left out instead of written with references that would fail at apply time. Otherwise, such
+// as when -secret-pattern leaves it without a Secret, it is only skipped.
+func (inj *envInjector) skipEmpty(source SourceWorkload, fails *failures) {
+	namespace := namespaceOf(source.Workload.ObjectMeta())
+	var others []string
+	for _, other := range inj.manifests.sourceNamespaces() {
+		if other != namespace {
+			others = append(others, other)
+		}
+	}
+
+	if len(others) > 0 {
+		fails.refuse(source.File, source.Kind, workloadName(source), "No Secret or ConfigMap in namespace %s for %s %s in file %s: refusing to reference the ones in %s, since Kubernetes only allows references within the workload's own namespace. Set their metadata.namespace to %s, or move the workload to their namespace\n", namespace, source.Kind, workloadName(source), source.File, strings.Join(others, ", "), namespace)
+		return
+	}
+	logFields{File: source.File, Kind: source.Kind, Name: workloadName(source)}.errorf("No Secret or ConfigMap in namespace %s for %s %s: skipping\n", namespace, source.Kind, workloadName(source))
+	fails.skip(source.File, source.Kind, workloadName(source), "no Secret or ConfigMap in namespace "+namespace)
+}
+
+// sourcesFor picks the Secrets and ConfigMaps for a workload, along with a key identifying that choice
+func (inj *envInjector) sourcesFor(source SourceWorkload) (string, []Secret, []ConfigMap) {
+	namespace := namespaceOf(source.Workload.ObjectMeta())
+	sources := inj.sourcesIn(namespace)
+
+	// A -secret-for rule matching the workload's annotations picks the Secret from its file
+	if rule, ok := matchSecretRule(inj.opts.secretFor, source.Workload.ObjectMeta()); ok {
+		if other := namespaceOf(rule.Secret.Metadata); other != namespace {
+			logFields{File: source.File, Kind: source.Kind, Name: workloadName(source)}.warnf("Secret %s from %s is in namespace %s, %s %s in namespace %s can't reference it: using the default Secrets\n", objectName(rule.Secret.Metadata), rule.File, other, source.Kind, workloadName(source), namespace)
+		} else {
+			infof("Using Secret %s from %s for %s %s annotated %s=%s\n", objectName(rule.Secret.Metadata), rule.File, source.Kind, workloadName(source), rule.Key, rule.Value)
+			return namespace + "/" + rule.File, []Secret{rule.Secret}, sources.configMaps
+		}
+	}
+
+	// Prefer the Secret named after the workload
+	if inj.opts.secretPattern != "" {
+		wanted := secretNameFor(inj.opts.secretPattern, workloadName(source))
+		for _, secret := range sources.secrets {
+			if name, _ := injector.MetadataString(secret.Metadata, "name"); name == wanted {
+				infof("Using Secret %s for %s %s\n", wanted, source.Kind, workloadName(source))
+				return namespace + "/" + wanted, []Secret{secret}, sources.configMaps
+			}
+		}
+	}
+
+	if len(inj.dedicated) == 0 {
+		return namespace, selectSecret(sources.secrets, inj.opts.secretSelect), sources.configMaps
+	}
+
+	// Fall back to the shared Secrets
+	var shared []Secret
+	for _, secret := range sources.secrets {
+		if name, _ := injector.MetadataString(secret.Metadata, "name"); !inj.dedicated[name] {
+			shared = append(shared, secret)
+		}
+	}
+	return namespace + "/", selectSecret(shared, inj.opts.secretSelect), sources.configMaps
+}
+
+// selectSecret narrows the Secrets down to the one -secret-select picks: the first found, or the newest
+// or oldest by metadata.creationTimestamp, where Secrets without a timestamp come last. Without a
+// -secret-select every Secret is kept.
+func selectSecret(secrets []Secret, mode string) []Secret {
+	if mode == "" || len(secrets) < 2 {
+		return secrets
+	}
+
+	ordered := append([]Secret{}, secrets...)
+	if mode != "first" {
+		sort.SliceStable(ordered, func(i, j int) bool {
+			a, aOK := creationTimestamp(ordered[i].Metadata)
+			b, bOK := creationTimestamp(ordered[j].Metadata)
+			if !aOK || !bOK {
+				return aOK
+			}
+			if mode == "oldest" {
+				return a.Before(b)
+			}
+			return a.After(b)
+		})
+	}
+	debugf("-secret-select %s picked Secret %s out of %d\n", mode, objectName(ordered[0].Metadata), len(secrets))
+	return ordered[:1]
+}
+
+// creationTimestamp returns metadata.creationTimestamp, which YAML decodes into a time or leaves
+// as a string when it is quoted
+func creationTimestamp(metadata map[string]interface{}) (time.Time, bool) {
+	switch value := metadata["creationTimestamp"].(type) {
+	case time.Time:
+		return value, true
+	case string:
+		t, err := time.Parse(time.RFC3339, value)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// sourcesIn returns the Secrets and ConfigMaps of a namespace, looking them up once per namespace
+func (inj *envInjector) sourcesIn(namespace string) *namespaceSources {
+	sources, ok := inj.namespaces[namespace]
+	if !ok {
+		secrets, configMaps := inj.manifests.inNamespace(namespace)
+		sources = &namespaceSources{configMaps: configMaps}
+		for _, secret := range secrets {
+			switch {
+			case injector.EnvSecret(&secret):
+				sources.secrets = append(sources.secrets, secret)
+			case injector.PullSecret(&secret):
+				name, _ := injector.MetadataString(secret.Metadata, "name")
+				sources.pullSecrets = append(sources.pullSecrets, name)
+			}
+		}
+		inj.namespaces[namespace] = sources
+	}
+	return sources
+}
+
+// secretRule is a -secret-for rule: workloads annotated Key=Value get the Secret from File
+type secretRule struct {
+	Key    string
+	Value  string
+	File   string
+	Secret Secret
+}
+
+// parseSecretRule parses a -secret-for rule written as key=value:file
+func parseSecretRule(text string) (secretRule, error) {
+	key, rest, ok := strings.Cut(text, "=")
+	if !ok || key == "" {
+		return secretRule{}, fmt.Errorf("must be key=value:file")
+	}
+	value, file, ok := strings.Cut(rest, ":")
+	if !ok || file == "" {
+		return secretRule{}, fmt.Errorf("must be key=value:file")
+	}
+	return secretRule{Key: key, Value: value, File: file}, nil
+}
+
+// matchSecretRule returns the first rule whose annotation the workload has with the same value
+func matchSecretRule(rules []secretRule, metadata map[string]interface{}) (secretRule, bool) {
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	for _, rule := range rules {
+		if value, ok := annotations[rule.Key].(string); ok && value == rule.Value {
+			return rule, true
+		}
+	}
+	return secretRule{}, false
+}
+
+// secretNameFor fills the workload name into a -secret-pattern such as {name}-secret
+func secretNameFor(pattern, workloadName string) string {
+	return strings.ReplaceAll(pattern, "{name}", workloadName)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSourcesForSecretPattern(t *testing.T) {
+	named := func(name string) map[string]interface{} { return map[string]interface{}{"name": name} }
+	manifests := &Manifests{
+		Secrets: []Secret{
+			{Metadata: named("shared")},
+			{Metadata: named("payments-secret")},
+		},
+		Workloads: []SourceWorkload{
+			{Kind: "Deployment", Workload: &Deployment{Metadata: named("payments")}},
+			{Kind: "Deployment", Workload: &Deployment{Metadata: named("orders")}},
+		},
+	}
+	inj := newEnvInjector(manifests, &options{secretPattern: "{name}-secret"}, newFailures(false))
+
+	tests := []struct {
+		workload SourceWorkload
+		want     string
+	}{
+		{manifests.Workloads[0], "payments-secret"},
+		{manifests.Workloads[1], "shared"},
+	}
+
+	for _, tt := range tests {
+		_, secrets, _ := inj.sourcesFor(tt.workload)
+		if len(secrets) != 1 || objectName(secrets[0].Metadata) != tt.want {
+			t.Errorf("sourcesFor(%s) secrets = %+v, want only %s", workloadName(tt.workload), secrets, tt.want)
+		}
+	}
+}
+
+func TestInjectPullSecret(t *testing.T) {
+	named := func(name string) map[string]interface{} { return map[string]interface{}{"name": name} }
+	dep := &Deployment{Metadata: named("api")}
+	dep.Spec.Template.Spec.Containers = []Container{{Name: "app"}}
+	manifests := &Manifests{
+		Secrets: []Secret{
+			{Metadata: named("app-secret"), Data: map[string]string{"api_key": "a2V5"}},
+			{Metadata: named("registry"), Type: "kubernetes.io/dockerconfigjson", Data: map[string]string{".dockerconfigjson": "e30="}},
+			{Metadata: named("tls"), Type: "kubernetes.io/tls", Data: map[string]string{"tls.crt": "e30="}},
+		},
+		Workloads: []SourceWorkload{{Kind: "Deployment", Workload: dep}},
+	}
+	inj := newEnvInjector(manifests, &options{caseMode: "upper"}, newFailures(false))
+
+	if _, ok := inj.inject(manifests.Workloads[0]); !ok {
+		t.Fatal("inject() = false, want the Secrets injected")
+	}
+	podSpec := dep.Spec.Template.Spec
+	if len(podSpec.ImagePullSecrets) != 1 || podSpec.ImagePullSecrets[0].Name != "registry" {
+		t.Errorf("imagePullSecrets = %+v, want only registry", podSpec.ImagePullSecrets)
+	}
+	if env := podSpec.Containers[0].Env; len(env) != 1 || env[0].Name != "API_KEY" {
+		t.Errorf("container env = %+v, want only API_KEY", env)
+	}
+}
+
+func TestInjectNamespaces(t *testing.T) {
+	metadata := func(name, namespace string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "namespace": namespace}
+	}
+	workload := func(name, namespace string) SourceWorkload {
+		dep := &Deployment{Metadata: metadata(name, namespace)}
+		dep.Spec.Template.Spec.Containers = []Container{{Name: "app"}}
+		return SourceWorkload{Kind: "Deployment", Workload: dep}
+	}
+	manifests := &Manifests{
+		Secrets: []Secret{
+			{Metadata: metadata("app-secret", ""), Data: map[string]string{"api_key": "a2V5"}},
+			{Metadata: metadata("staging-secret", "staging"), Data: map[string]string{"token": "dG9r"}},
+		},
+	}
+	inj := newEnvInjector(manifests, &options{caseMode: "upper"}, newFailures(false))
+
+	tests := []struct {
+		workload SourceWorkload
+		want     bool
+	}{
+		{workload("api", "default"), true},
+		{workload("api", ""), true},
+		{workload("api", "production"), false},
+	}
+
+	for _, tt := range tests {
+		if _, ok := inj.inject(tt.workload); ok != tt.want {
+			t.Errorf("inject() into namespace %s = %v, want %v", namespaceOf(tt.workload.Workload.ObjectMeta()), ok, tt.want)
+		}
+	}
+	if got := manifests.sourceNamespaces(); len(got) != 2 || got[0] != "default" || got[1] != "staging" {
+		t.Errorf("sourceNamespaces() = %v, want [default staging]", got)
+	}
+}
+
+func TestCheckRefusesCrossNamespace(t *testing.T) {
+	dep := &Deployment{Metadata: map[string]interface{}{"name": "api", "namespace": "production"}}
+	dep.Spec.Template.Spec.Containers = []Container{{Name: "app"}}
+	source := SourceWorkload{Kind: "Deployment", Workload: dep, File: "api.yaml"}
+	manifests := &Manifests{
+		Secrets:   []Secret{{Metadata: map[string]interface{}{"name": "app-secret", "namespace": "staging"}, Data: map[string]string{"api_key": "a2V5"}}},
+		Workloads: []SourceWorkload{source},
+	}
+
+	fails := newFailures(false)
+	newEnvInjector(manifests, &options{caseMode: "upper"}, fails).check(source, fails)
+	if fails.refused != 1 {
+		t.Errorf("refused = %d, want 1", fails.refused)
+	}
+
+	// -env-from names no env vars, but the references would still cross namespaces
+	fails = newFailures(false)
+	newEnvInjector(manifests, &options{envFrom: true}, fails).check(source, fails)
+	if fails.refused != 1 || len(fails.errors) != 1 || !strings.Contains(fails.errors[0], "metadata.namespace to production") {
+		t.Errorf("errors = %q, want the cross-namespace reference refused with a fix", fails.errors)
+	}
+	if len(fails.skipped) != 1 || fails.skipped[0].Name != "api" {
+		t.Errorf("skipped = %+v, want api", fails.skipped)
+	}
+}
+
+func TestCheckSkipsSameNamespace(t *testing.T) {
+	named := func(name string) map[string]interface{} { return map[string]interface{}{"name": name} }
+	orders := SourceWorkload{Kind: "Deployment", Workload: &Deployment{Metadata: named("orders")}}
+	orders.Workload.PodSpec().Containers = []Container{{Name: "app"}}
+	manifests := &Manifests{
+		Secrets:   []Secret{{Metadata: named("payments-secret"), Data: map[string]string{"token": "dG9r"}}},
+		Workloads: []SourceWorkload{{Kind: "Deployment", Workload: &Deployment{Metadata: named("payments")}}, orders},
+	}
+
+	fails := newFailures(false)
+	newEnvInjector(manifests, &options{secretPattern: "{name}-secret"}, fails).check(orders, fails)
+	if fails.refused != 0 || len(fails.errors) != 0 || len(fails.skipped) != 1 {
+		t.Errorf("refused = %d, errors = %q, skipped = %+v, want orders only skipped", fails.refused, fails.errors, fails.skipped)
+	}
+}
+
+func TestSourcesForSecretRule(t *testing.T) {
+	metadata := func(name, tier string) map[string]interface{} {
+		m := map[string]interface{}{"name": name}
+		if tier != "" {
+			m["annotations"] = map[string]interface{}{"tier": tier}
+		}
+		return m
+	}
+	frontend := Secret{Metadata: metadata("frontend-secret", "")}
+	manifests := &Manifests{
+		Secrets: []Secret{{Metadata: metadata("default-secret", "")}, frontend},
+		Workloads: []SourceWorkload{
+			{Kind: "Deployment", Workload: &Deployment{Metadata: metadata("web", "frontend")}},
+			{Kind: "Deployment", Workload: &Deployment{Metadata: metadata("worker", "backend")}},
+		},
+	}
+	rules := []secretRule{{Key: "tier", Value: "frontend", File: "frontend-secret.yaml", Secret: frontend}}
+	inj := newEnvInjector(manifests, &options{secretFor: rules}, newFailures(false))
+
+	tests := []struct {
+		workload SourceWorkload
+		want     string
+	}{
+		{manifests.Workloads[0], "frontend-secret"},
+		{manifests.Workloads[1], "default-secret"},
+	}
+
+	for _, tt := range tests {
+		_, secrets, _ := inj.sourcesFor(tt.workload)
+		if len(secrets) != 1 || objectName(secrets[0].Metadata) != tt.want {
+			t.Errorf("sourcesFor(%s) secrets = %+v, want only %s", workloadName(tt.workload), secrets, tt.want)
+		}
+	}
+}
+
+func TestParseSecretRule(t *testing.T) {
+	rule, err := parseSecretRule("tier=frontend:secrets/frontend.yaml")
+	if err != nil || rule.Key != "tier" || rule.Value != "frontend" || rule.File != "secrets/frontend.yaml" {
+		t.Errorf("parseSecretRule() = %+v, %v", rule, err)
+	}
+	for _, text := range []string{"tier", "tier=frontend", "=frontend:a.yaml", "tier=frontend:"} {
+		if _, err := parseSecretRule(text); err == nil {
+			t.Errorf("parseSecretRule(%q) expected an error", text)
+		}
+	}
+}
+
+func TestSelectSecret(t *testing.T) {
+	data := `apiVersion: v1
+kind: Secret
+metadata:
+  name: unstamped
+stringData:
+  token: a
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: old
+  creationTimestamp: 2024-01-01T00:00:00Z
+stringData:
+  token: b
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: new
+  creationTimestamp: "2025-06-01T12:00:00Z"
+stringData:
+  token: c
+`
+	m := &Manifests{}
+	m.parseFile("secrets.yaml", []byte(data), &options{}, newFailures(false))
+
+	for mode, want := range map[string]string{"first": "unstamped", "newest": "new", "oldest": "old"} {
+		got := selectSecret(m.Secrets, mode)
+		if len(got) != 1 || objectName(got[0].Metadata) != want {
+			t.Errorf("selectSecret(%s) = %+v, want only %s", mode, got, want)
+		}
+	}
+	if got := selectSecret(m.Secrets, ""); len(got) != 3 {
+		t.Errorf("selectSecret() without a mode kept %d Secrets, want 3", len(got))
+	}
+}
+
+func TestInjectRecordsWarnings(t *testing.T) {
+	dep := &Deployment{Metadata: map[string]interface{}{"name": "api"}}
+	dep.Spec.Template.Spec.Containers = []Container{{Name: "app"}}
+	manifests := &Manifests{
+		Secrets:   []Secret{{Metadata: map[string]interface{}{"name": "app-secret"}, Data: map[string]string{"db.url": "dXJs"}}},
+		Workloads: []SourceWorkload{{Kind: "Deployment", Workload: dep}},
+	}
+	fails := newFailures(false)
+	inj := newEnvInjector(manifests, &options{caseMode: "upper"}, fails)
+
+	if _, ok := inj.inject(manifests.Workloads[0]); !ok {
+		t.Fatal("inject() = false, want the Secret injected")
+	}
+	if len(fails.warnings) != 1 || !strings.Contains(fails.warnings[0], "DB.URL") {
+		t.Errorf("warnings = %q, want the invalid name DB.URL", fails.warnings)
+	}
+}
+
+func TestCheckReportsEnvVarProblems(t *testing.T) {
+	workload := func(name string) SourceWorkload {
+		dep := &Deployment{Metadata: map[string]interface{}{"name": name}}
+		dep.Spec.Template.Spec.Containers = []Container{{Name: "app"}}
+		return SourceWorkload{Kind: "Deployment", Workload: dep}
+	}
+	manifests := &Manifests{
+		Secrets: []Secret{{
+			Metadata: map[string]interface{}{"name": "app-secret"},
+			Data:     map[string]string{"api_key": "YQ==", "API_KEY": "Yg==", "db.url": "dXJs"},
+			Keys:     []string{"api_key", "API_KEY", "db.url"},
+		}},
+		Workloads: []SourceWorkload{workload("api"), workload("worker")},
+	}
+
+	fails := newFailures(false)
+	inj := newEnvInjector(manifests, &options{caseMode: "upper", check: true}, fails)
+	for _, source := range manifests.Workloads {
+		inj.check(source, fails)
+	}
+	if len(fails.warnings) != 2 {
+		t.Errorf("warnings = %q, want the collision and the invalid name once each", fails.warnings)
+	}
+	if env := manifests.Workloads[0].Workload.PodSpec().Containers[0].Env; len(env) != 0 {
+		t.Errorf("check() injected %+v, want nothing injected", env)
+	}
+
+	fails = newFailures(false)
+	inj = newEnvInjector(manifests, &options{caseMode: "upper", check: true, strict: true}, fails)
+	inj.check(manifests.Workloads[0], fails)
+	if len(fails.warnings) != 1 {
+		t.Errorf("warnings = %q, want the error under -strict reported", fails.warnings)
+	}
+}
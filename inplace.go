@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sourceFormat is the format a source file is written back in by -in-place, going by its extension
+func sourceFormat(file string) string {
+	if filepath.Ext(strings.TrimSuffix(strings.ToLower(file), gzipExtension)) == ".json" {
+		return "json"
+	}
+	return "yaml"
+}
+
+// rewriteInPlace builds the new content of a source file for -in-place: the updated documents, keyed by their
+// position in the file, replace the originals while every other document, such as a Secret, is kept as it was
+func rewriteInPlace(file string, updated map[int][]byte) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err = decompress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// A JSON file holds a single document, which is the updated workload
+	if sourceFormat(file) == "json" {
+		document, ok := updated[1]
+		if !ok || len(updated) != 1 {
+			return nil, fmt.Errorf("expected a single updated document in a JSON file")
+		}
+		return document, nil
+	}
+
+	var documents [][]byte
+	decoder := yaml.NewDecoder(bytes.NewReader(normalizeInput(data)))
+	for doc := 1; ; doc++ {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", doc, err)
+		}
+
+		document, ok := updated[doc]
+		if !ok {
+			document, err = marshalNode(&node)
+			if err != nil {
+				return nil, fmt.Errorf("document %d: %v", doc, err)
+			}
+		}
+		documents = append(documents, document)
+	}
+	return combineDocuments(documents, "yaml")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRewriteInPlace(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.yaml")
+	data := "# shared credentials\napiVersion: v1\nkind: Secret\nmetadata:\n  name: app-secret\nstringData:\n  token: abc\n---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: old\n"
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := rewriteInPlace(file, map[int][]byte{2: []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: new\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# shared credentials\napiVersion: v1\nkind: Secret\nmetadata:\n  name: app-secret\nstringData:\n  token: abc\n---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: new\n"
+	if string(got) != want {
+		t.Errorf("rewriteInPlace() = %q, want %q", got, want)
+	}
+}
+
+func TestSourceFormat(t *testing.T) {
+	for file, want := range map[string]string{"app.yaml": "yaml", "app.yml.gz": "yaml", "app.json": "json", "APP.JSON.gz": "json", "app": "yaml"} {
+		if got := sourceFormat(file); got != want {
+			t.Errorf("sourceFormat(%s) = %s, want %s", file, got, want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log levels: warnings and errors are always printed, -v adds info and -vv adds debug messages
+const (
+	levelWarn = iota
+	levelInfo
+	levelDebug
+)
+
+// verbosity is the highest level printed, set once from the flags before any work starts
+var verbosity = levelWarn
+
+// jsonLogs is set by -log-format json to print every message as a JSON object on its own line
+var jsonLogs bool
+
+// logMu keeps messages printed from the parsing workers from interleaving
+var logMu sync.Mutex
+
+// logFields describes what a message is about. In JSON logs every field that is set becomes
+// a field of its own, in text logs they are only part of the message.
+type logFields struct {
+	File string
+	Kind string
+	Name string
+}
+
+// logEntry is a message in -log-format json
+type logEntry struct {
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+	File  string `json:"file,omitempty"`
+	Kind  string `json:"kind,omitempty"`
+	Name  string `json:"name,omitempty"`
+}
+
+// logf prints a message to stderr, as is or as a JSON log entry. The text prefix, such as
+// "Warning: ", is left out of JSON entries since the level already says as much.
+func logf(level, prefix string, fields logFields, format string, args ...interface{}) {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	if !jsonLogs {
+		fmt.Fprintf(os.Stderr, prefix+format, args...)
+		return
+	}
+
+	entry := logEntry{
+		Time:  time.Now().UTC().Format(time.RFC3339),
+		Level: level,
+		Msg:   strings.TrimSpace(fmt.Sprintf(format, args...)),
+		File:  fields.File,
+		Kind:  fields.Kind,
+		Name:  fields.Name,
+	}
+	data, _ := json.Marshal(entry)
+	fmt.Fprintf(os.Stderr, "%s\n", data)
+}
+
+// infof prints a progress message when running with -v or -vv
+func (fields logFields) infof(format string, args ...interface{}) {
+	if verbosity >= levelInfo {
+		logf("info", "", fields, format, args...)
+	}
+}
+
+// debugf prints a detailed message when running with -vv
+func (fields logFields) debugf(format string, args ...interface{}) {
+	if verbosity >= levelDebug {
+		logf("debug", "", fields, format, args...)
+	}
+}
+
+// warnf prints a warning, which is always shown
+func (fields logFields) warnf(format string, args ...interface{}) {
+	logf("warn", "Warning: ", fields, format, args...)
+}
+
+// errorf prints an error or any other message that is always shown
+func (fields logFields) errorf(format string, args ...interface{}) {
+	logf("error", "", fields, format, args...)
+}
+
+// infof prints a progress message to stderr when running with -v or -vv
+func infof(format string, args ...interface{}) {
+	logFields{}.infof(format, args...)
+}
+
+// debugf prints a detailed message to stderr when running with -vv
+func debugf(format string, args ...interface{}) {
+	logFields{}.debugf(format, args...)
+}
+
+// warnf prints a warning to stderr
+func warnf(format string, args ...interface{}) {
+	logFields{}.warnf(format, args...)
+}
+
+// errorf prints a message to stderr regardless of the verbosity
+func errorf(format string, args ...interface{}) {
+	logFields{}.errorf(format, args...)
+}
+
+// fatalLogWriter turns the messages of log.Fatalf into JSON log entries once -log-format json is set
+type fatalLogWriter struct{}
+
+func (fatalLogWriter) Write(p []byte) (int, error) {
+	logf("fatal", "", logFields{}, "%s", p)
+	return len(p), nil
+}
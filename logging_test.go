@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestLogfJSON(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	jsonLogs = true
+	defer func() {
+		os.Stderr = stderr
+		jsonLogs = false
+	}()
+
+	logFields{File: "app.yaml", Kind: "Deployment", Name: "api"}.warnf("%s %s has no containers\n", "Deployment", "api")
+	w.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entry logEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("log line %q is not JSON: %v", data, err)
+	}
+	want := logEntry{Time: entry.Time, Level: "warn", Msg: "Deployment api has no containers", File: "app.yaml", Kind: "Deployment", Name: "api"}
+	if entry != want || entry.Time == "" {
+		t.Errorf("log entry = %+v, want %+v", entry, want)
+	}
+}
+
+// captureJSONLogs returns the JSON log entries fn prints to stderr
+func captureJSONLogs(t *testing.T, fn func()) []logEntry {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	jsonLogs = true
+	defer func() {
+		os.Stderr = stderr
+		jsonLogs = false
+	}()
+
+	fn()
+	w.Close()
+	var entries []logEntry
+	decoder := json.NewDecoder(r)
+	for {
+		var entry logEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("log output is not JSON: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func TestLogSummaryJSON(t *testing.T) {
+	entries := captureJSONLogs(t, func() {
+		logSummary([]summaryRow{{Kind: "Deployment", Workload: "api", Container: "app", Count: 2}})
+		logCounts([]kindCount{{Kind: "Deployment", Processed: 1, Skipped: 1}})
+	})
+
+	want := []logEntry{
+		{Level: "info", Msg: "Injected 2 env vars into container app", Kind: "Deployment", Name: "api"},
+		{Level: "info", Msg: "Total: 2 env vars injected into 1 containers"},
+		{Level: "info", Msg: "1 processed, 1 skipped", Kind: "Deployment"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("log entries = %+v, want %+v", entries, want)
+	}
+	for i := range want {
+		want[i].Time = entries[i].Time
+		if entries[i] != want[i] {
+			t.Errorf("log entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
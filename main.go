@@ -1,194 +1,874 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
+	"env-deployment-k8s/pkg/injector"
 	"gopkg.in/yaml.v3"
 )
 
-type Secret struct {
-	APIVersion string                 `yaml:"apiVersion"`
-	Kind       string                 `yaml:"kind"`
-	Metadata   map[string]interface{} `yaml:"metadata"`
-	Data       map[string]string      `yaml:"data"`
+// SourceWorkload is a workload together with its kind and the document node and file it was read from.
+// The node keeps comments, field order and unknown fields so they survive the rewrite.
+type SourceWorkload struct {
+	Kind     string
+	Workload workload
+	Node     *yaml.Node
+	File     string
+
+	// Document is the position of the workload's document in File, counting from 1
+	Document int
+
+	// Compressed is set when the source file was gzip-compressed
+	Compressed bool
 }
 
-type Deployment struct {
-	APIVersion string                 `yaml:"apiVersion"`
-	Kind       string                 `yaml:"kind"`
-	Metadata   map[string]interface{} `yaml:"metadata"`
-	Spec       DeploymentSpec         `yaml:"spec"`
+// stringList is a flag that can be repeated, collecting every value
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
-type DeploymentSpec struct {
-	Selector map[string]interface{} `yaml:"selector"`
-	Template PodTemplate            `yaml:"template"`
+// options holds the settings taken from the command line
+type options struct {
+	dir                string
+	out                string
+	merge              bool
+	dryRun             bool
+	recursive          bool
+	preserveDirs       bool
+	caseMode           string
+	sanitize           bool
+	envPrefix          string
+	envFrom            bool
+	validateSecrets    bool
+	strict             bool
+	ext                string
+	workers            int
+	format             string
+	selectors          map[string]string
+	namespace          string
+	secretPattern      string
+	secretFor          []secretRule
+	secretSelect       string
+	namePrefix         string
+	nameSuffix         string
+	renameSecrets      bool
+	sortMode           string
+	sortKeys           string
+	images             map[string]string
+	quiet              bool
+	count              bool
+	check              bool
+	defaultRequests    map[string]string
+	defaultLimits      map[string]string
+	secretDir          string
+	deployDir          string
+	filesList          string
+	tar                string
+	context            string
+	logFormat          string
+	failOnEmptySecret  bool
+	annotations        map[string]string
+	prune              bool
+	inPlace            bool
+	secretName         string
+	force              bool
+	report             string
+	gzip               bool
+	skipAnnotation     string
+	includeKeys        map[string]bool
+	excludeKeys        map[string]bool
+	sortContainers     bool
+	secretKeys         []string
+	envSecretName      string
+	envFile            string
+	writeSecret        string
+	schemaValidate     bool
+	combine            bool
+	containers         map[string]bool
+	imageMatch         string
+	marker             string
+	documentStart      bool
+	maxFileSize        int64
+	replicas           int
+	timeout            time.Duration
+	literalEnv         []EnvVar
+	selector           injector.ContainerSelector
+	secretFile         string
+	diff               bool
+	checksumAnnotation bool
+	excludes           []string
+	outTemplate        *template.Template
+	backup             bool
+	stdin              bool
+	verbose            bool
+	debug              bool
 }
 
-type PodTemplate struct {
-	Metadata map[string]interface{} `yaml:"metadata"`
-	Spec     PodSpec                `yaml:"spec"`
+// injectorOptions returns the settings the injector package needs to build and inject the env vars
+func (opts *options) injectorOptions(fails *failures) injector.Options {
+	var secretPrefix, secretSuffix string
+	if opts.renameSecrets {
+		secretPrefix, secretSuffix = opts.namePrefix, opts.nameSuffix
+	}
+	return injector.Options{
+		Case:         opts.caseMode,
+		Prefix:       opts.envPrefix,
+		IncludeKeys:  opts.includeKeys,
+		ExcludeKeys:  opts.excludeKeys,
+		Sort:         opts.sortMode,
+		SortKeys:     opts.sortKeys,
+		Env:          opts.literalEnv,
+		SecretName:   opts.secretName,
+		SecretPrefix: secretPrefix,
+		SecretSuffix: secretSuffix,
+		Sanitize:     opts.sanitize,
+		Strict:       opts.strict,
+		Merge:        opts.merge,
+		Containers:   opts.selector,
+		Warnf:        fails.note,
+		Printf:       infof,
+	}
 }
 
-type PodSpec struct {
-	Containers []Container `yaml:"containers"`
+// parseOptions reads the command-line flags and checks that their values are usable
+func parseOptions() *options {
+	opts := &options{}
+	flag.StringVar(&opts.dir, "dir", ".", "directory containing the YAML manifests")
+	flag.StringVar(&opts.context, "context", "", "process only the overlay in this subdirectory of -dir, such as dev or prod")
+	flag.StringVar(&opts.secretDir, "secret-dir", "", "directory containing the Secrets and ConfigMaps (default -dir)")
+	flag.StringVar(&opts.filesList, "files", "", "file listing the manifests to process in order, one path per line, instead of listing the directories")
+	flag.StringVar(&opts.tar, "tar", "", "read the manifests from this tar archive, optionally gzip-compressed, instead of the directories; needs -out")
+	flag.StringVar(&opts.deployDir, "deploy-dir", "", "directory containing the workloads, and where their output goes by default (default -dir)")
+	flag.StringVar(&opts.out, "out", "", "output directory, or a .yaml/.yml/.json file to combine all updated workloads into")
+	flag.BoolVar(&opts.merge, "merge", false, "keep existing container env vars and only add or overwrite the ones from the Secret")
+	flag.BoolVar(&opts.diff, "diff", false, "print a unified diff of every updated workload to stdout; with -dry-run only the diff is printed")
+	flag.BoolVar(&opts.check, "check", false, "only parse and validate the manifests, with -validate-secrets and -schema-validate, writing nothing and exiting non-zero on any problem")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "print the updated deployments to stdout instead of writing files")
+	flag.BoolVar(&opts.recursive, "recursive", false, "look for YAML files in subdirectories as well")
+	flag.BoolVar(&opts.combine, "combine", false, "write every updated workload, sorted by name, into a single all.yaml (or all.json) in the output directory")
+	flag.BoolVar(&opts.gzip, "gzip", false, "gzip the output of workloads read from gzip-compressed files, adding .gz to the filename")
+	flag.BoolVar(&opts.preserveDirs, "preserve-dirs", false, "mirror the input subdirectory structure in the output directory")
+	flag.StringVar(&opts.caseMode, "case", "upper", "case of the generated env var names: upper, lower or preserve")
+	noUppercase := flag.Bool("no-uppercase", false, "keep the env var names as the keys are written, the same as -case preserve")
+	flag.BoolVar(&opts.sanitize, "sanitize", false, "replace the characters env var names can't have, such as the dot in db.url, with _ instead of only warning about them")
+	asciiUpper := flag.Bool("ascii-upper", false, "upper-case only the ASCII letters of the env var names, leaving others such as ß untouched")
+	flag.StringVar(&opts.envPrefix, "env-prefix", "", "prefix, joined with _, to prepend to every generated env var name")
+	flag.BoolVar(&opts.envFrom, "env-from", false, "reference each Secret and ConfigMap once with envFrom instead of adding an env var per key")
+	flag.StringVar(&opts.sortMode, "sort", "name", "order of the generated env vars: name, or none to keep the order keys are declared in")
+	flag.StringVar(&opts.sortKeys, "sort-keys", "", "order the keys of every Secret and ConfigMap are read in: asc or desc, declared order when empty. The env vars keep it with -sort none")
+	flag.BoolVar(&opts.sortContainers, "sort-containers", false, "order the containers of every pod by name")
+	flag.BoolVar(&opts.validateSecrets, "validate-secrets", false, "treat Secret data values that are not valid base64 as errors instead of warnings")
+	flag.BoolVar(&opts.schemaValidate, "schema-validate", false, "report fields the Kubernetes schema of the resource doesn't define, such as a misspelled containers")
+	flag.BoolVar(&opts.strict, "strict", false, "stop at the first error instead of continuing with the remaining files")
+	flag.StringVar(&opts.ext, "ext", "yaml,yml", "comma-separated list of file extensions to process (case-insensitive)")
+	flag.StringVar(&opts.format, "format", "yaml", "output format: yaml or json")
+	var selectFlags stringList
+	flag.Var(&selectFlags, "select", "only process workloads with the metadata label key=value (repeatable, all must match)")
+	flag.StringVar(&opts.skipAnnotation, "skip-annotation", "env-injector/skip", "workloads with this annotation set to \"true\" are left unchanged, empty to disable")
+	flag.StringVar(&opts.namespace, "namespace", "", "only process resources in this namespace (resources without one are in default)")
+	flag.StringVar(&opts.secretFile, "secret", "", "inject only the Secret in this file, ignoring every other Secret found")
+	includeKeys := flag.String("include-keys", "", "comma-separated Secret and ConfigMap keys to inject, skipping every other key")
+	excludeKeys := flag.String("exclude-keys", "", "comma-separated Secret and ConfigMap keys not to inject")
+	secretKeys := flag.String("secret-keys", "", "comma-separated keys of a Secret built from the process env vars of the same name, e.g. db_url,api_key")
+	flag.BoolVar(&opts.failOnEmptySecret, "fail-on-empty-secret", false, "exit with an error instead of a warning when a Secret has no data or stringData keys")
+	flag.StringVar(&opts.secretName, "secret-name", "", "name of the Secret to reference in the env vars instead of the parsed Secret's own, for one created in the cluster by something else")
+	flag.StringVar(&opts.envFile, "env-file", "", "dotenv file of KEY=VALUE lines to build a Secret from, for local development")
+	flag.StringVar(&opts.envSecretName, "env-secret-name", "env-secret", "name of the Secret built by -secret-keys or -env-file")
+	flag.StringVar(&opts.writeSecret, "write-secret", "", "also save the Secret built by -secret-keys or -env-file to this file")
+	var secretForFlags stringList
+	flag.Var(&secretForFlags, "secret-for", "inject the Secret in a file into the workloads with an annotation, as key=value:file, e.g. tier=frontend:frontend-secret.yaml; other workloads get the default Secrets (repeatable)")
+	flag.StringVar(&opts.namePrefix, "name-prefix", "", "prepend this to the name of every workload, and to the labels holding its name, for an isolated copy")
+	flag.StringVar(&opts.nameSuffix, "name-suffix", "", "append this to the name of every workload, and to the labels holding its name, for an isolated copy")
+	flag.BoolVar(&opts.renameSecrets, "rename-secrets", false, "also put -name-prefix and -name-suffix around the names of the Secrets referenced, for copies of the Secrets renamed the same way")
+	flag.StringVar(&opts.secretSelect, "secret-select", "", "inject only one of a namespace's Secrets instead of all of them: first, or the newest or oldest by metadata.creationTimestamp")
+	flag.StringVar(&opts.secretPattern, "secret-pattern", "", "name of the Secret dedicated to each workload, with {name} for the workload name (e.g. {name}-secret); workloads without one get the shared Secrets")
+	containers := flag.String("containers", "", "comma-separated names of the containers to inject into, all containers when empty")
+	flag.IntVar(&opts.replicas, "replicas", -1, "set spec.replicas of every Deployment, StatefulSet and ReplicaSet, keeping the replicas as written when unset")
+	flag.Int64Var(&opts.maxFileSize, "max-file-size", 10<<20, "skip input files larger than this many bytes, 0 for no limit")
+	flag.DurationVar(&opts.timeout, "timeout", 0, "stop starting new work after this long, e.g. 5m, writing what is already done and reporting the rest as skipped; 0 for no limit")
+	flag.BoolVar(&opts.force, "force", false, "write output files even when their content is unchanged")
+	flag.BoolVar(&opts.documentStart, "document-start", false, "start every YAML output file with the --- document marker")
+	flag.StringVar(&opts.imageMatch, "image-match", "", "only inject into containers whose image matches this glob, e.g. registry.internal/*")
+	flag.StringVar(&opts.marker, "marker", "", "only inject into containers that declare an env var with this name, e.g. __INJECT_SECRETS__, replacing it with the injected env vars")
+	var excludeFlags stringList
+	flag.Var(&excludeFlags, "exclude", "skip files whose base name matches this glob, e.g. kustomization.yaml or values*.yaml (repeatable)")
+	defaultRequests := flag.String("default-requests", "", "resource requests for containers that don't set them, e.g. cpu=100m,memory=128Mi")
+	defaultLimits := flag.String("default-limits", "", "resource limits for containers that don't set them, e.g. cpu=500m,memory=256Mi")
+	flag.BoolVar(&opts.prune, "prune", false, "remove the output files this tool generated whose workloads are no longer in the input, told apart by their header comment or, for JSON, the "+generatedAnnotation+" annotation -prune adds")
+	var annotateFlags stringList
+	flag.Var(&annotateFlags, "annotate", "add an annotation to the metadata of every updated workload, as key=value (repeatable)")
+	var envFlags stringList
+	flag.Var(&envFlags, "env", "add a literal env var to every container, as KEY=VALUE (repeatable)")
+	var imageFlags stringList
+	flag.Var(&imageFlags, "image", "set the image of the container with this name, as name=repo:tag (repeatable)")
+	outTemplate := flag.String("out-template", defaultOutTemplate, "Go template for output filenames, with {{.Name}}, {{.Namespace}}, {{.Kind}} and {{.Format}}")
+	flag.BoolVar(&opts.checksumAnnotation, "checksum-annotation", false, "add a checksum/secret annotation to the pod template so pods roll when a Secret changes")
+	flag.BoolVar(&opts.inPlace, "in-place", false, "write the updated workloads back into their source files instead of *_updated files, keeping the other documents in them; see -backup")
+	flag.BoolVar(&opts.backup, "backup", false, "copy each source workload file to <file>.bak before writing any output")
+	flag.BoolVar(&opts.verbose, "v", false, "also print progress messages such as the files written")
+	flag.BoolVar(&opts.debug, "vv", false, "also print debug messages such as every file and document processed")
+	flag.StringVar(&opts.report, "report", "", "write a JSON report of the files processed, skipped resources, injected env vars and errors to this file")
+	flag.BoolVar(&opts.quiet, "quiet", false, "don't print the summary at the end of the run")
+	flag.BoolVar(&opts.count, "count", false, "print how many resources of each kind were found, processed and skipped at the end of the run")
+	flag.IntVar(&opts.workers, "workers", runtime.NumCPU(), "number of files to read and parse concurrently")
+	flag.StringVar(&opts.logFormat, "log-format", "text", "format of the messages on stderr: text, or json for one JSON object per message")
+	flag.Parse()
+
+	// Set up the log format first so every message that follows, fatal ones included, uses it
+	switch opts.logFormat {
+	case "text":
+	case "json":
+		jsonLogs = true
+		log.SetFlags(0)
+		log.SetOutput(fatalLogWriter{})
+	default:
+		log.Fatalf("Invalid -log-format %q: must be text or json", opts.logFormat)
+	}
+
+	// A check runs every validation there is
+	if opts.check {
+		opts.validateSecrets = true
+		opts.schemaValidate = true
+	}
+
+	if opts.context != "" {
+		opts.dir = contextDir(opts.dir, opts.context)
+	}
+	if opts.secretDir == "" {
+		opts.secretDir = opts.dir
+	}
+	if opts.deployDir == "" {
+		opts.deployDir = opts.dir
+	}
+
+	// Archive entries are named under the archive's path, so -preserve-dirs keeps their layout in -out
+	if opts.tar != "" {
+		if opts.out == "" && !opts.dryRun && !opts.check {
+			log.Fatalf("Invalid -tar %s: -out is required to write the updated workloads", opts.tar)
+		}
+		if opts.backup {
+			log.Fatalf("Invalid -tar %s: -backup can't be used with an archive", opts.tar)
+		}
+		opts.deployDir = opts.tar
+	}
+
+	switch {
+	case opts.debug:
+		verbosity = levelDebug
+	case opts.verbose:
+		verbosity = levelInfo
+	}
+	if opts.caseMode != "upper" && opts.caseMode != "lower" && opts.caseMode != "preserve" {
+		log.Fatalf("Invalid -case %q: must be upper, lower or preserve", opts.caseMode)
+	}
+	if *noUppercase && *asciiUpper {
+		log.Fatalf("Invalid -no-uppercase: can't be used with -ascii-upper")
+	}
+	if (*noUppercase || *asciiUpper) && opts.caseMode != "upper" {
+		log.Fatalf("Invalid -case %s: can't be used with -no-uppercase or -ascii-upper", opts.caseMode)
+	}
+	if *noUppercase {
+		opts.caseMode = "preserve"
+	}
+	if *asciiUpper {
+		opts.caseMode = "ascii-upper"
+	}
+	if opts.renameSecrets && opts.namePrefix == "" && opts.nameSuffix == "" {
+		log.Fatalf("Invalid -rename-secrets: needs -name-prefix or -name-suffix")
+	}
+	if opts.secretSelect != "" && opts.secretSelect != "first" && opts.secretSelect != "newest" && opts.secretSelect != "oldest" {
+		log.Fatalf("Invalid -secret-select %q: must be first, newest or oldest", opts.secretSelect)
+	}
+	if opts.sortMode != "name" && opts.sortMode != "none" {
+		log.Fatalf("Invalid -sort %q: must be name or none", opts.sortMode)
+	}
+	if opts.sortKeys != "" && opts.sortKeys != "asc" && opts.sortKeys != "desc" {
+		log.Fatalf("Invalid -sort-keys %q: must be asc or desc", opts.sortKeys)
+	}
+	if opts.format != "yaml" && opts.format != "json" {
+		log.Fatalf("Invalid -format %q: must be yaml or json", opts.format)
+	}
+	tmpl, err := parseOutTemplate(*outTemplate)
+	if err != nil {
+		log.Fatalf("Invalid -out-template %q: %v", *outTemplate, err)
+	}
+	opts.outTemplate = tmpl
+	if opts.workers < 1 {
+		log.Fatalf("Invalid -workers %d: must be at least 1", opts.workers)
+	}
+	if opts.replicas < -1 {
+		log.Fatalf("Invalid -replicas %d: must not be negative", opts.replicas)
+	}
+	if opts.timeout < 0 {
+		log.Fatalf("Invalid -timeout %s: must not be negative", opts.timeout)
+	}
+
+	opts.selectors = make(map[string]string)
+	for _, selector := range selectFlags {
+		key, value, ok := strings.Cut(selector, "=")
+		if !ok || key == "" {
+			log.Fatalf("Invalid -select %q: must be key=value", selector)
+		}
+		opts.selectors[key] = value
+	}
+
+	for _, pattern := range excludeFlags {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			log.Fatalf("Invalid -exclude %q: %v", pattern, err)
+		}
+	}
+	opts.excludes = excludeFlags
+
+	opts.secretKeys = splitList(*secretKeys)
+	if opts.envFile != "" && len(opts.secretKeys) > 0 {
+		log.Fatalf("Invalid -env-file: can't be combined with -secret-keys, both build the -env-secret-name Secret")
+	}
+	opts.containers = listSet(*containers)
+	if _, err := path.Match(opts.imageMatch, ""); err != nil {
+		log.Fatalf("Invalid -image-match %q: %v", opts.imageMatch, err)
+	}
+	opts.selector = injector.ContainerSelector{Names: opts.containers, Image: opts.imageMatch, Marker: opts.marker}
+	opts.includeKeys = listSet(*includeKeys)
+	opts.excludeKeys = listSet(*excludeKeys)
+	if opts.envFrom && (len(opts.includeKeys) > 0 || len(opts.excludeKeys) > 0) {
+		log.Fatalf("-include-keys and -exclude-keys can't be used with -env-from, which references whole Secrets and ConfigMaps")
+	}
+
+	for _, text := range secretForFlags {
+		rule, err := parseSecretRule(text)
+		if err != nil {
+			log.Fatalf("Invalid -secret-for %q: %v", text, err)
+		}
+		opts.secretFor = append(opts.secretFor, rule)
+	}
+
+	opts.annotations = make(map[string]string)
+	for _, annotation := range annotateFlags {
+		key, value, ok := strings.Cut(annotation, "=")
+		if !ok || key == "" {
+			log.Fatalf("Invalid -annotate %q: must be key=value", annotation)
+		}
+		opts.annotations[key] = value
+	}
+	if opts.inPlace {
+		switch {
+		case opts.out != "" || opts.combine:
+			log.Fatalf("Invalid -in-place: can't be used with -out or -combine")
+		case opts.tar != "":
+			log.Fatalf("Invalid -in-place: can't be used with -tar")
+		case opts.prune:
+			log.Fatalf("Invalid -in-place: can't be used with -prune")
+		}
+	}
+	if opts.prune {
+		if _, combinedFile := outputLocation(opts); combinedFile != "" {
+			log.Fatalf("Invalid -prune: needs an output directory, not the combined output file %s", combinedFile)
+		}
+		// YAML output is recognized by its header, JSON has no comment to carry one
+		if opts.format == "json" {
+			opts.annotations[generatedAnnotation] = "true"
+		}
+	}
+
+	for _, env := range envFlags {
+		name, value, ok := strings.Cut(env, "=")
+		if !ok || name == "" {
+			log.Fatalf("Invalid -env %q: must be KEY=VALUE", env)
+		}
+		opts.literalEnv = append(opts.literalEnv, EnvVar{Name: name, Value: value})
+	}
+	if opts.envFrom && len(opts.literalEnv) > 0 {
+		log.Fatalf("-env can't be used with -env-from, which only references whole Secrets and ConfigMaps")
+	}
+
+	opts.defaultRequests = parseResourceList("-default-requests", *defaultRequests)
+	opts.defaultLimits = parseResourceList("-default-limits", *defaultLimits)
+
+	opts.images = make(map[string]string)
+	for _, image := range imageFlags {
+		name, ref, ok := strings.Cut(image, "=")
+		if !ok || name == "" || ref == "" {
+			log.Fatalf("Invalid -image %q: must be name=repo:tag", image)
+		}
+		opts.images[name] = ref
+	}
+
+	// Read a manifest stream from stdin when asked with -, or when something is piped in and no
+	// input directory, -files list or -tar archive was given. The updated workloads then go to stdout as with -dry-run.
+	dirSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "dir" || f.Name == "secret-dir" || f.Name == "deploy-dir" || f.Name == "files" || f.Name == "tar" || f.Name == "context" {
+			dirSet = true
+		}
+	})
+	opts.stdin = flag.Arg(0) == "-" || (flag.NArg() == 0 && !dirSet && stdinIsPiped())
+	if opts.stdin {
+		opts.dryRun = true
+	}
+
+	return opts
 }
 
-type Container struct {
-	Name  string   `yaml:"name"`
-	Image string   `yaml:"image"`
-	Ports []Port   `yaml:"ports"`
-	Env   []EnvVar `yaml:"env"`
+// stdinIsPiped reports whether stdin is a pipe or a redirected file rather than a terminal
+func stdinIsPiped() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&(os.ModeNamedPipe|os.ModeCharDevice) == os.ModeNamedPipe || info.Mode().IsRegular()
 }
 
-type Port struct {
-	ContainerPort int `yaml:"containerPort"`
+// splitList splits a comma-separated flag value, dropping blanks and surrounding spaces
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
-type EnvVar struct {
-	Name      string        `yaml:"name"`
-	ValueFrom *ValueFromRef `yaml:"valueFrom"`
+// parseResourceList parses a comma-separated list of resource=quantity pairs
+func parseResourceList(flagName, list string) map[string]string {
+	resources := make(map[string]string)
+	for _, item := range splitList(list) {
+		name, quantity, ok := strings.Cut(item, "=")
+		if !ok || name == "" || quantity == "" {
+			log.Fatalf("Invalid %s %q: must be resource=quantity, e.g. cpu=100m", flagName, item)
+		}
+		resources[name] = quantity
+	}
+	return resources
 }
 
-type ValueFromRef struct {
-	SecretKeyRef SecretKeyRef `yaml:"secretKeyRef"`
+// listSet is splitList returning the items as a set
+func listSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range splitList(list) {
+		set[item] = true
+	}
+	return set
 }
 
-type SecretKeyRef struct {
-	Name string `yaml:"name"`
-	Key  string `yaml:"key"`
+// failures keeps track of what went wrong so the exit status can reflect it, along with the
+// messages and skipped resources for -report.
+// It is shared by the parse workers, so every access goes through the mutex.
+type failures struct {
+	mu       sync.Mutex
+	files    map[string]bool
+	writes   int
+	refused  int
+	strict   bool
+	errors   []string
+	warnings []string
+	skipped  []reportSkip
+	timedOut bool
+
+	// located are the file:line: messages of the documents that could not be parsed, listed again at the end
+	located []string
 }
 
-func main() {
-	// Directory containing YAML files
-	dir := "."
+func newFailures(strict bool) *failures {
+	return &failures{files: make(map[string]bool), strict: strict}
+}
 
-	// List all .yaml files in the directory
-	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
-	if err != nil {
-		log.Fatalf("Failed to list YAML files: %v", err)
+// parse reports a file that could not be read or parsed
+func (f *failures) parse(file, format string, args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	logFields{File: file}.errorf(format, args...)
+	f.files[file] = true
+	f.errors = append(f.errors, strings.TrimSpace(fmt.Sprintf(format, args...)))
+	if f.strict {
+		log.Fatalf("Stopping at the first error because -strict is set")
 	}
+}
 
-	var secret *Secret
-	var deployments []Deployment
+// parseAt reports a document that could not be parsed as file:line: message, which editors can jump to.
+// Without a line the message is only prefixed with the file.
+func (f *failures) parseAt(file string, line int, format string, args ...interface{}) {
+	location := file
+	if line > 0 {
+		location = fmt.Sprintf("%s:%d", file, line)
+	}
+	message := location + ": " + fmt.Sprintf(format, args...)
 
-	for _, file := range files {
-		fmt.Printf("Processing file: %s\n", file)
+	f.mu.Lock()
+	f.located = append(f.located, strings.TrimSpace(message))
+	f.mu.Unlock()
+	f.parse(file, "%s", message)
+}
 
-		// Read the YAML file
-		data, err := os.ReadFile(file)
-		if err != nil {
-			fmt.Printf("Failed to read file %s: %v\n", file, err)
-			continue
-		}
+// warn reports a problem that is only fatal under -strict
+func (f *failures) warn(format string, args ...interface{}) {
+	if f.strict {
+		log.Fatalf(format, args...)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	warnf(format, args...)
+	f.warnings = append(f.warnings, strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
 
-		// Unmarshal the YAML data into a generic map
-		var genericYaml map[string]interface{}
-		err = yaml.Unmarshal(data, &genericYaml)
-		if err != nil {
-			fmt.Printf("Failed to parse YAML in file %s: %v\n", file, err)
-			continue
-		}
-
-		// Determine if the file is a Secret or a Deployment
-		apiVersion, apiVersionOk := genericYaml["apiVersion"].(string)
-		kind, kindOk := genericYaml["kind"].(string)
-
-		if !apiVersionOk || !kindOk {
-			fmt.Printf("File %s does not have valid apiVersion or kind: skipping\n", file)
-			continue
-		}
-
-		// Process based on kind
-		switch kind {
-		case "Secret":
-			if apiVersion == "v1" {
-				var sec Secret
-				err := yaml.Unmarshal(data, &sec)
-				if err != nil {
-					fmt.Printf("Failed to parse Secret YAML in file %s: %v\n", file, err)
-					continue
-				}
-				secret = &sec
-				fmt.Printf("Valid Secret found in file %s\n", file)
-			}
+// note records a warning for -report and -check like warn, but doesn't stop the run under -strict.
+// It is for the warnings that were never fatal, and the injector's, which reports errors itself under
+// Options.Strict.
+func (f *failures) note(format string, args ...interface{}) {
+	f.noteFor(logFields{}, format, args...)
+}
 
-		case "Deployment":
-			if apiVersion == "apps/v1" {
-				var dep Deployment
-				err := yaml.Unmarshal(data, &dep)
-				if err != nil {
-					fmt.Printf("Failed to parse Deployment YAML in file %s: %v\n", file, err)
-					continue
-				}
-				deployments = append(deployments, dep)
-				fmt.Printf("Valid Deployment found in file %s\n", file)
-			}
+// noteFor is note for a message about a particular file or object, which JSON logs carry as fields
+func (f *failures) noteFor(fields logFields, format string, args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fields.warnf(format, args...)
+	f.warnings = append(f.warnings, strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
 
-		default:
-			fmt.Printf("File %s is not a Secret or Deployment: skipping\n", file)
-		}
+// refuse reports a workload that is left out since it would have to reference Secrets or ConfigMaps
+// in another namespace. The run carries on with the other workloads but fails in the end.
+func (f *failures) refuse(file, kind, name, format string, args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	logFields{File: file, Kind: kind, Name: name}.errorf(format, args...)
+	f.refused++
+	f.errors = append(f.errors, strings.TrimSpace(fmt.Sprintf(format, args...)))
+	f.skipped = append(f.skipped, reportSkip{File: file, Kind: kind, Name: name, Reason: "Secrets and ConfigMaps only in other namespaces"})
+	if f.strict {
+		log.Fatalf("Stopping at the first error because -strict is set")
 	}
+}
 
-	// Process the Deployment files only if a valid Secret is found
-	if secret == nil {
-		fmt.Println("No valid Secret found, skipping Deployment processing")
-		return
+// write reports a workload that could not be written
+func (f *failures) write(format string, args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	errorf(format, args...)
+	f.writes++
+	f.errors = append(f.errors, strings.TrimSpace(fmt.Sprintf(format, args...)))
+	if f.strict {
+		log.Fatalf("Stopping at the first error because -strict is set")
 	}
+}
+
+// skip records a document or workload left out of the run, the caller prints the message
+func (f *failures) skip(file, kind, name, reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.skipped = append(f.skipped, reportSkip{File: file, Kind: kind, Name: name, Reason: reason})
+}
+
+// timeout records a file or workload left unprocessed because the -timeout ran out
+func (f *failures) timeout(file, kind, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.timedOut = true
+	f.skipped = append(f.skipped, reportSkip{File: file, Kind: kind, Name: name, Reason: "not processed before -timeout"})
+}
+
+// complete reports whether every input file was parsed, so the run saw all the workloads there are
+func (f *failures) complete() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.files) == 0 && !f.timedOut
+}
 
-	for _, deployment := range deployments {
-		// Clear all existing environment variables
-		for i := range deployment.Spec.Template.Spec.Containers {
-			deployment.Spec.Template.Spec.Containers[i].Env = []EnvVar{}
+// skipDropped records the workloads a filtering step dropped, all for the same reason
+func (f *failures) skipDropped(before, after []SourceWorkload, reason string) {
+	kept := make(map[workload]bool, len(after))
+	for _, source := range after {
+		kept[source.Workload] = true
+	}
+	for _, source := range before {
+		if !kept[source.Workload] {
+			name, _ := injector.MetadataString(source.Workload.ObjectMeta(), "name")
+			f.skip(source.File, source.Kind, name, reason)
 		}
+	}
+}
 
-		// Create a slice to hold the new environment variables
-		var newEnvVars []EnvVar
+// failed reports whether the run failed, with f.mu held. A -check also fails on any error or warning.
+func (f *failures) failed(check bool) bool {
+	if len(f.files) > 0 || f.writes > 0 || f.refused > 0 || f.timedOut {
+		return true
+	}
+	return check && len(f.errors)+len(f.warnings) > 0
+}
 
-		// Add environment variables from the Secret, convert names to uppercase
-		for key := range secret.Data {
-			newEnvVars = append(newEnvVars, EnvVar{
-				Name: strings.ToUpper(key),
-				ValueFrom: &ValueFromRef{
-					SecretKeyRef: SecretKeyRef{
-						Name: secret.Metadata["name"].(string),
-						Key:  key,
-					},
-				},
-			})
+// exitOnFailures prints a summary and exits non-zero if anything failed
+func (f *failures) exitOnFailures(totalFiles int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.located) > 0 {
+		sort.Strings(f.located)
+		errorf("Parse errors:\n")
+		for _, message := range f.located {
+			errorf("  %s\n", message)
 		}
+	}
+	if len(f.files) > 0 {
+		errorf("%d of %d files failed\n", len(f.files), totalFiles)
+	}
+	if f.writes > 0 {
+		errorf("%d deployments failed to write\n", f.writes)
+	}
+	if f.refused > 0 {
+		errorf("%d workloads refused to reference Secrets or ConfigMaps in another namespace\n", f.refused)
+	}
+	if f.timedOut {
+		errorf("Stopped by -timeout before everything was processed\n")
+	}
+	if f.failed(false) {
+		os.Exit(1)
+	}
+}
+
+// exitOnProblems ends a -check: any error or warning is a failure
+func (f *failures) exitOnProblems(totalFiles int) {
+	f.mu.Lock()
+	problems := len(f.errors) + len(f.warnings)
+	timedOut := f.timedOut
+	f.mu.Unlock()
 
-		// Sort the environment variables by Name
-		sort.Slice(newEnvVars, func(i, j int) bool {
-			return newEnvVars[i].Name < newEnvVars[j].Name
-		})
+	if timedOut {
+		errorf("Check stopped by -timeout before every workload was checked\n")
+		os.Exit(1)
+	}
+	if problems > 0 {
+		errorf("Check found %d problems in %d files\n", problems, totalFiles)
+		os.Exit(1)
+	}
+	errorf("Check passed for %d files\n", totalFiles)
+}
 
-		// Assign the sorted, uppercase environment variables to the container
-		for i := range deployment.Spec.Template.Spec.Containers {
-			deployment.Spec.Template.Spec.Containers[i].Env = newEnvVars
+// contextDir resolves a -context to its subdirectory of dir, exiting with the available
+// contexts when there is no such subdirectory
+func contextDir(dir, context string) string {
+	path := filepath.Join(dir, context)
+	if info, err := os.Stat(path); err == nil && info.IsDir() && filepath.Dir(path) == filepath.Clean(dir) {
+		return path
+	}
+
+	var available []string
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			available = append(available, entry.Name())
 		}
+	}
+	if len(available) == 0 {
+		log.Fatalf("Unknown -context %s: %s has no subdirectories", context, dir)
+	}
+	log.Fatalf("Unknown -context %s: available contexts in %s are %s", context, dir, strings.Join(available, ", "))
+	return ""
+}
+
+// listInputFiles lists the manifest files in dir, exiting when there are none
+func listInputFiles(dir string, opts *options) []string {
+	// Make sure the directory exists before looking for files in it
+	info, err := os.Stat(dir)
+	if err != nil {
+		log.Fatalf("Invalid directory %s: %v", dir, err)
+	}
+	if !info.IsDir() {
+		log.Fatalf("Invalid directory %s: not a directory", dir)
+	}
+
+	// List all YAML files in the directory
+	files, err := findYAMLFiles(dir, opts.recursive, parseExtensions(opts.ext))
+	if err != nil {
+		log.Fatalf("Failed to list YAML files: %v", err)
+	}
+	files = excludeFiles(files, opts.excludes)
+	if len(files) == 0 {
+		log.Fatalf("No files with extension %s found in %s", opts.ext, dir)
+	}
+	return files
+}
+
+// parseInputDirs parses -secret-dir for the Secrets and ConfigMaps and -deploy-dir for the workloads,
+// listing a directory only once when they are the same
+func parseInputDirs(ctx context.Context, opts *options, fails *failures) ([]string, *Manifests) {
+	if filepath.Clean(opts.secretDir) == filepath.Clean(opts.deployDir) {
+		files := listInputFiles(opts.deployDir, opts)
+		return files, parseFiles(ctx, files, opts, fails)
+	}
+
+	secretFiles := listInputFiles(opts.secretDir, opts)
+	deployFiles := listInputFiles(opts.deployDir, opts)
+	sources := parseFiles(ctx, secretFiles, opts, fails)
+	workloads := parseFiles(ctx, deployFiles, opts, fails)
+	debugf("Ignoring %d workloads in %s and %d Secrets and ConfigMaps in %s\n", len(sources.Workloads), opts.secretDir, len(workloads.Secrets)+len(workloads.ConfigMaps), opts.deployDir)
+
+	manifests := &Manifests{Secrets: sources.Secrets, ConfigMaps: sources.ConfigMaps, Workloads: workloads.Workloads}
+	return append(secretFiles, deployFiles...), manifests
+}
 
-		// Marshal the updated Deployment YAML
-		updatedDeploymentData, err := yaml.Marshal(&deployment)
+func main() {
+	opts := parseOptions()
+
+	// -timeout stops new files and workloads from being started once it runs out
+	ctx := context.Background()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	fails := newFailures(opts.strict)
+	var files []string
+	var manifests *Manifests
+	if opts.stdin {
+		files = []string{stdinName}
+		manifests = parseStdin(opts, fails)
+	} else if opts.filesList != "" {
+		var err error
+		files, err = readFileList(opts.filesList)
 		if err != nil {
-			fmt.Printf("Failed to marshal updated Deployment YAML: %v\n", err)
-			continue
+			log.Fatalf("Invalid -files %s: %v", opts.filesList, err)
 		}
-
-		// Write the updated Deployment YAML to a new file
-		outputFile := "deployment_updated.yaml"
-		outputPath := filepath.Join(dir, outputFile)
-		err = os.WriteFile(outputPath, updatedDeploymentData, 0644)
+		manifests = parseFiles(ctx, files, opts, fails)
+	} else if opts.tar != "" {
+		var err error
+		files, manifests, err = parseTar(ctx, opts.tar, opts, fails)
 		if err != nil {
-			fmt.Printf("Failed to write updated Deployment file %s: %v\n", outputPath, err)
-			continue
+			log.Fatalf("Invalid -tar %s: %v", opts.tar, err)
+		}
+	} else {
+		files, manifests = parseInputDirs(ctx, opts, fails)
+	}
+
+	// An explicit -secret replaces whatever Secrets were found in the input
+	if opts.secretFile != "" {
+		manifests.Secrets = []Secret{loadSecretFile("-secret", opts.secretFile, opts)}
+	}
+
+	// The Secrets of the -secret-for rules are loaded up front, so a bad file stops the run before anything is written
+	for i := range opts.secretFor {
+		opts.secretFor[i].Secret = loadSecretFile("-secret-for", opts.secretFor[i].File, opts)
+	}
+
+	// Build a Secret from the process env, or a dotenv file, so the values never have to be committed
+	if len(opts.secretKeys) > 0 || opts.envFile != "" {
+		var secret Secret
+		var err error
+		if opts.envFile != "" {
+			secret, err = secretFromEnvFile(opts.envSecretName, opts.namespace, opts.envFile)
+			if err != nil {
+				log.Fatalf("Failed to build Secret %s from -env-file %s: %v", opts.envSecretName, opts.envFile, err)
+			}
+		} else {
+			secret, err = secretFromEnv(opts.envSecretName, opts.namespace, opts.secretKeys)
+			if err != nil {
+				log.Fatalf("Failed to build Secret %s from -secret-keys: %v", opts.envSecretName, err)
+			}
+		}
+		manifests.Secrets = append(manifests.Secrets, secret)
+		if opts.writeSecret != "" && !opts.dryRun {
+			err = writeSecret(opts.writeSecret, secret)
+			if err != nil {
+				log.Fatalf("Failed to write Secret %s to %s: %v", opts.envSecretName, opts.writeSecret, err)
+			}
+			infof("Secret %s saved to %s\n", opts.envSecretName, opts.writeSecret)
+		}
+	}
+
+	// Remember every workload in the input, filtered out or not, so -prune keeps their output files
+	current := workloadKeys(manifests.Workloads, opts.namePrefix, opts.nameSuffix)
+
+	// Leave out everything outside the requested namespace
+	if opts.namespace != "" {
+		before := manifests.Workloads
+		manifests.filterNamespace(opts.namespace)
+		fails.skipDropped(before, manifests.Workloads, "not in namespace "+opts.namespace)
+	}
+
+	// Process the workload files only if a valid Secret or ConfigMap is found
+	if len(manifests.Secrets) == 0 && len(manifests.ConfigMaps) == 0 && len(opts.secretFor) == 0 {
+		errorf("No valid Secret or ConfigMap found, skipping workload processing\n")
+		fails.skipDropped(manifests.Workloads, nil, "no valid Secret or ConfigMap found")
+		reportCounts(manifests, nil, opts, fails)
+		finish(opts, files, nil, fails)
+		return
+	}
+
+	workloads := selectWorkloads(manifests.Workloads, opts.selectors)
+	fails.skipDropped(manifests.Workloads, workloads, "labels don't match -select")
+	selected := workloads
+	workloads = skipAnnotated(workloads, opts.skipAnnotation)
+	fails.skipDropped(selected, workloads, "annotated "+opts.skipAnnotation)
+	sortWorkloads(workloads)
+
+	// A check stops before anything is injected or written
+	if opts.check {
+		injector := newEnvInjector(manifests, opts, fails)
+		for i, source := range workloads {
+			if ctx.Err() != nil {
+				skipTimedOut(workloads[i:], fails)
+				break
+			}
+			if validateWorkload(source, injector, opts, fails) {
+				injector.check(source, fails)
+			}
 		}
+		reportCounts(manifests, workloads, opts, fails)
+		finish(opts, files, nil, fails)
+		return
+	}
+
+	summary := writeWorkloads(ctx, workloads, newEnvInjector(manifests, opts, fails), opts, fails)
+	if opts.prune {
+		pruneOutputs(current, files, opts, fails)
+	}
+	if !opts.quiet && jsonLogs {
+		logSummary(summary)
+	} else if !opts.quiet {
+		printSummary(os.Stderr, summary)
+	}
+	reportCounts(manifests, workloads, opts, fails)
+
+	finish(opts, files, summary, fails)
+}
 
-		fmt.Printf("Updated Deployment YAML saved to %s\n", outputPath)
+// skipTimedOut records the workloads the -timeout cut off
+func skipTimedOut(workloads []SourceWorkload, fails *failures) {
+	errorf("Timed out: skipping %d workloads not yet processed\n", len(workloads))
+	for _, source := range workloads {
+		fails.timeout(source.File, source.Kind, workloadName(source))
+	}
+}
+
+// finish writes the -report, even when something failed, and exits with the run's status.
+// A -check fails on warnings too.
+func finish(opts *options, files []string, summary []summaryRow, fails *failures) {
+	if opts.report != "" {
+		err := writeReport(opts.report, files, summary, fails, opts.check)
+		if err != nil {
+			log.Fatalf("Failed to write report %s: %v", opts.report, err)
+		}
+		infof("Report saved to %s\n", opts.report)
+	}
+	if opts.check {
+		fails.exitOnProblems(len(files))
+		return
 	}
+	fails.exitOnFailures(len(files))
 }
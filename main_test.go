@@ -0,0 +1,188 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestHelperMain runs main with the arguments after --. It only does anything as the subprocess
+// started by runTool, since main parses the global flags and exits on failure.
+func TestHelperMain(t *testing.T) {
+	if os.Getenv("ENV_INJECTOR_HELPER_MAIN") != "1" {
+		t.Skip("only runs as a subprocess of runTool")
+	}
+	for i, arg := range os.Args {
+		if arg == "--" {
+			os.Args = append([]string{"env-deployment-k8s"}, os.Args[i+1:]...)
+			break
+		}
+	}
+	main()
+}
+
+// runTool runs the tool with args in a subprocess and returns its combined output and exit error
+func runTool(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestHelperMain$", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), "ENV_INJECTOR_HELPER_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestRunTwiceBesideInputs(t *testing.T) {
+	dir := t.TempDir()
+	data := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app-secret\nstringData:\n  token: abc\n---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\nspec:\n  template:\n    spec:\n      containers:\n        - name: api\n"
+	if err := os.WriteFile(filepath.Join(dir, "app.yaml"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for run := 1; run <= 2; run++ {
+		if out, err := runTool(t, "-dir", dir, "-strict"); err != nil {
+			t.Fatalf("run %d failed: %v\n%s", run, err, out)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	want := []string{filepath.Join(dir, "api_updated.yaml"), filepath.Join(dir, "app.yaml")}
+	if len(files) != len(want) || files[0] != want[0] || files[1] != want[1] {
+		t.Errorf("files after two runs = %v, want %v", files, want)
+	}
+}
+
+func TestEnvFileWithSecretKeys(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, ".env")
+	if err := os.WriteFile(envFile, []byte("DB_URL=postgres://db\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runTool(t, "-dir", dir, "-env-file", envFile, "-secret-keys", "db_url")
+	if err == nil {
+		t.Fatalf("-env-file with -secret-keys succeeded, want it rejected:\n%s", out)
+	}
+	if !strings.Contains(out, "Invalid -env-file") {
+		t.Errorf("output does not explain the rejection:\n%s", out)
+	}
+}
+
+func TestInPlaceBackupUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	data := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app-secret\nstringData:\n  token: abc\n---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\nspec:\n  template:\n    spec:\n      containers:\n        - name: api\n"
+	if err := os.WriteFile(filepath.Join(dir, "app.yaml"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for run := 1; run <= 3; run++ {
+		if out, err := runTool(t, "-dir", dir, "-in-place", "-backup"); err != nil {
+			t.Fatalf("run %d failed: %v\n%s", run, err, out)
+		}
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, "app.yaml.bak*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Errorf("backups after three runs = %v, want only the one of the first run", backups)
+	}
+}
+
+func TestSecretFileProblems(t *testing.T) {
+	dir := t.TempDir()
+	deployment := "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\nspec:\n  template:\n    spec:\n      containers:\n        - name: api\n"
+	if err := os.WriteFile(filepath.Join(dir, "api.yaml"), []byte(deployment), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A warning, here an unknown field, doesn't stop the run, and the file may be compressed like any input
+	secret, err := compress([]byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: app-secret\nstringDta:\n  typo: x\ndata:\n  token: YWJj\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretFile := filepath.Join(t.TempDir(), "secret.yaml.gz")
+	if err := os.WriteFile(secretFile, secret, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runTool(t, "-dir", dir, "-secret", secretFile, "-schema-validate", "-dry-run"); err != nil {
+		t.Errorf("-secret with a schema warning failed: %v\n%s", err, out)
+	}
+
+	// An error names the -secret file and the problem rather than -strict
+	badFile := filepath.Join(t.TempDir(), "secret.yaml")
+	if err := os.WriteFile(badFile, []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: app-secret\ndata:\n  token: not base64!\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := runTool(t, "-dir", dir, "-secret", badFile, "-validate-secrets", "-dry-run")
+	if err == nil {
+		t.Fatalf("-secret with invalid base64 succeeded:\n%s", out)
+	}
+	if !strings.Contains(out, "Invalid -secret file") || !strings.Contains(out, "not valid base64") || strings.Contains(out, "-strict") {
+		t.Errorf("output does not report the -secret problem:\n%s", out)
+	}
+}
+
+func TestCheckCountsParseWarnings(t *testing.T) {
+	dir := t.TempDir()
+	data := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app-secret\nstringData:\n  token: abc\n---\napiVersion: extensions/v1beta1\nkind: Deployment\nmetadata:\n  name: api\n"
+	if err := os.WriteFile(filepath.Join(dir, "app.yaml"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runTool(t, "-dir", dir, "-check")
+	if err == nil || !strings.Contains(out, "Check found 1 problems") {
+		t.Errorf("-check with a deprecated apiVersion = %v, want it to fail:\n%s", err, out)
+	}
+}
+
+func TestBackupUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	out := t.TempDir()
+	data := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app-secret\nstringData:\n  token: abc\n---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\nspec:\n  template:\n    spec:\n      containers:\n        - name: api\n"
+	if err := os.WriteFile(filepath.Join(dir, "app.yaml"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for run := 1; run <= 3; run++ {
+		if output, err := runTool(t, "-dir", dir, "-out", out, "-backup"); err != nil {
+			t.Fatalf("run %d failed: %v\n%s", run, err, output)
+		}
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, "app.yaml.bak*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Errorf("backups after three runs = %v, want only the one of the first run", backups)
+	}
+}
+
+func TestPruneAnnotatesJSONOnly(t *testing.T) {
+	data := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app-secret\nstringData:\n  token: abc\n---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\nspec:\n  template:\n    spec:\n      containers:\n        - name: api\n"
+
+	for format, want := range map[string]bool{"yaml": false, "json": true} {
+		dir := t.TempDir()
+		out := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "app.yaml"), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if output, err := runTool(t, "-dir", dir, "-out", out, "-prune", "-format", format); err != nil {
+			t.Fatalf("-format %s failed: %v\n%s", format, err, output)
+		}
+		written, err := os.ReadFile(filepath.Join(out, "api_updated."+format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(string(written), generatedAnnotation); got != want {
+			t.Errorf("-format %s output has the annotation = %v, want %v:\n%s", format, got, want, written)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+
+	"env-deployment-k8s/pkg/injector"
+)
+
+// namespaceOf returns an object's metadata.namespace, which Kubernetes treats as default when it is missing or empty
+func namespaceOf(metadata map[string]interface{}) string {
+	if namespace, ok := injector.MetadataString(metadata, "namespace"); ok {
+		return namespace
+	}
+	return "default"
+}
+
+// filterNamespace drops the Secrets, ConfigMaps and workloads outside the namespace
+func (m *Manifests) filterNamespace(namespace string) {
+	var secrets []Secret
+	for _, secret := range m.Secrets {
+		if namespaceOf(secret.Metadata) == namespace {
+			secrets = append(secrets, secret)
+		} else {
+			infof("Skipping Secret %s: not in namespace %s\n", objectName(secret.Metadata), namespace)
+		}
+	}
+
+	var configMaps []ConfigMap
+	for _, configMap := range m.ConfigMaps {
+		if namespaceOf(configMap.Metadata) == namespace {
+			configMaps = append(configMaps, configMap)
+		} else {
+			infof("Skipping ConfigMap %s: not in namespace %s\n", objectName(configMap.Metadata), namespace)
+		}
+	}
+
+	var workloads []SourceWorkload
+	for _, source := range m.Workloads {
+		if namespaceOf(source.Workload.ObjectMeta()) == namespace {
+			workloads = append(workloads, source)
+		} else {
+			infof("Skipping %s %s in file %s: not in namespace %s\n", source.Kind, workloadName(source), source.File, namespace)
+		}
+	}
+
+	m.Secrets, m.ConfigMaps, m.Workloads = secrets, configMaps, workloads
+}
+
+// inNamespace returns the Secrets and ConfigMaps that workloads in the namespace may reference,
+// since Kubernetes doesn't allow cross-namespace references. A workload left without any is
+// reported when it is skipped.
+func (m *Manifests) inNamespace(namespace string) ([]Secret, []ConfigMap) {
+	var secrets []Secret
+	for _, secret := range m.Secrets {
+		if namespaceOf(secret.Metadata) == namespace {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	var configMaps []ConfigMap
+	for _, configMap := range m.ConfigMaps {
+		if namespaceOf(configMap.Metadata) == namespace {
+			configMaps = append(configMaps, configMap)
+		}
+	}
+
+	return secrets, configMaps
+}
+
+// sourceNamespaces lists the namespaces holding any Secret or ConfigMap, in order
+func (m *Manifests) sourceNamespaces() []string {
+	seen := make(map[string]bool)
+	for _, secret := range m.Secrets {
+		seen[namespaceOf(secret.Metadata)] = true
+	}
+	for _, configMap := range m.ConfigMaps {
+		seen[namespaceOf(configMap.Metadata)] = true
+	}
+
+	namespaces := make([]string, 0, len(seen))
+	for namespace := range seen {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// objectName returns metadata.name for log messages, or a placeholder when it is missing
+func objectName(metadata map[string]interface{}) string {
+	if name, ok := injector.MetadataString(metadata, "name"); ok {
+		return name
+	}
+	return "<unnamed>"
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// lookupNode follows the mapping keys from node and returns the value found, or nil
+// if any key along the path is missing. Document nodes are unwrapped first.
+func lookupNode(node *yaml.Node, keys ...string) *yaml.Node {
+	for _, key := range keys {
+		node = mappingValue(node, key)
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// mappingValue returns the value stored under key in a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	node = unwrapDocument(node)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mappingKeys returns the keys of a mapping node in document order, or nil if it isn't a mapping
+func mappingKeys(node *yaml.Node) []string {
+	node = unwrapDocument(node)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	keys := make([]string, 0, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keys = append(keys, node.Content[i].Value)
+	}
+	return keys
+}
+
+// setNodeValue encodes value and stores it under key in a mapping node,
+// replacing the existing value in place or appending the key when it is missing
+func setNodeValue(node *yaml.Node, key string, value interface{}) error {
+	node = unwrapDocument(node)
+
+	var valueNode yaml.Node
+	err := valueNode.Encode(value)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = &valueNode
+			return nil
+		}
+	}
+
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+	node.Content = append(node.Content, keyNode, &valueNode)
+	return nil
+}
+
+// mappingChild follows the mapping keys from node like lookupNode, adding an empty mapping for
+// every key that is missing or null along the way
+func mappingChild(node *yaml.Node, keys ...string) (*yaml.Node, error) {
+	node = unwrapDocument(node)
+	for _, key := range keys {
+		child := mappingValue(node, key)
+		if child == nil || child.Tag == "!!null" {
+			// Built by hand since an encoded empty map would come out in flow style as {}
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			removeNodeKey(node, key)
+			parent := unwrapDocument(node)
+			parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+		}
+		if child.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s is not a mapping", key)
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// removeNodeKey deletes key and its value from a mapping node if it is present
+func removeNodeKey(node *yaml.Node, key string) {
+	node = unwrapDocument(node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// expandAliases replaces every alias in the node with a copy of the node it refers to and drops the
+// anchors, reporting whether there was anything to expand. Once expanded, changing one container
+// can't leak into another that shared its anchor, and every container is a plain mapping again.
+func expandAliases(node *yaml.Node) bool {
+	expanded := false
+	var expand func(node *yaml.Node)
+	expand = func(node *yaml.Node) {
+		for i, child := range node.Content {
+			if child.Kind == yaml.AliasNode {
+				node.Content[i] = copyNode(child.Alias)
+				expanded = true
+			}
+			expand(node.Content[i])
+		}
+	}
+	expand(node)
+
+	if expanded {
+		var clear func(node *yaml.Node)
+		clear = func(node *yaml.Node) {
+			node.Anchor = ""
+			for _, child := range node.Content {
+				clear(child)
+			}
+		}
+		clear(node)
+	}
+	return expanded
+}
+
+// copyNode returns a deep copy of a node
+func copyNode(node *yaml.Node) *yaml.Node {
+	copied := *node
+	copied.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		copied.Content[i] = copyNode(child)
+	}
+	return &copied
+}
+
+// unwrapDocument returns the root content of a document node, or the node itself
+func unwrapDocument(node *yaml.Node) *yaml.Node {
+	if node != nil && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		return node.Content[0]
+	}
+	return node
+}
+
+// marshalNode encodes a node with the two-space indentation used by Kubernetes manifests
+func marshalNode(node *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(node)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalNodeJSON encodes a node as indented JSON. Mapping keys keep the camelCase
+// names used in the manifest.
+func marshalNodeJSON(node *yaml.Node) ([]byte, error) {
+	var value interface{}
+	err := node.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,617 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"env-deployment-k8s/pkg/injector"
+	"gopkg.in/yaml.v3"
+)
+
+// outputLocation decides where the updated workloads go: next to the inputs, into a directory, or into one
+// combined file in that directory. combinedFile is empty unless the workloads are combined.
+func outputLocation(opts *options) (outputDir, combinedFile string) {
+	outputDir = opts.deployDir
+	if opts.out != "" {
+		outputDir = opts.out
+	}
+	if hasExtension(opts.out, parseExtensions("yaml,yml,json")) {
+		return filepath.Dir(opts.out), opts.out
+	}
+
+	// -combine writes every workload into all.yaml, or all.json, in the output directory
+	if opts.combine {
+		return outputDir, filepath.Join(outputDir, "all."+opts.format)
+	}
+	return outputDir, ""
+}
+
+// writeWorkloads injects the env vars into every workload and writes the results
+// next to the inputs, into the -out directory or combined file, or to stdout for a dry run.
+// It returns what was injected into each container for the summary. Once ctx is done the
+// remaining workloads are skipped, while those already done are still written.
+func writeWorkloads(ctx context.Context, workloads []SourceWorkload, injector *envInjector, opts *options, fails *failures) []summaryRow {
+	outputDir, combinedFile := outputLocation(opts)
+	combineOutput := combinedFile != ""
+	if opts.out != "" && !opts.dryRun {
+		err := os.MkdirAll(outputDir, 0755)
+		if err != nil {
+			log.Fatalf("Failed to create output directory %s: %v", outputDir, err)
+		}
+	}
+
+	// Track the output paths already used, and by which source file, so workloads sharing a name don't overwrite each other
+	usedNames := make(map[string]string)
+	var combinedDocuments [][]byte
+	var summary []summaryRow
+	matchedImages := make(map[string]bool)
+	matchedContainers := make(map[string]bool)
+	var combinedSources []string
+
+	// Keep a copy of a source file once, right before the first output for it is really written,
+	// so a run that changes nothing leaves no backups behind
+	backedUp := make(map[string]bool)
+	backUp := func(file string) bool {
+		if !opts.backup || backedUp[file] {
+			return true
+		}
+		backup, err := backupFile(file)
+		if err != nil {
+			fails.write("Failed to back up %s: %v\n", file, err)
+			return false
+		}
+		backedUp[file] = true
+		infof("Backed up %s to %s\n", file, backup)
+		return true
+	}
+	inPlaceDocuments := make(map[string]map[int][]byte)
+	var inPlaceFiles []SourceWorkload
+
+	for i, source := range workloads {
+		if ctx.Err() != nil {
+			skipTimedOut(workloads[i:], fails)
+			break
+		}
+		if !validateWorkload(source, injector, opts, fails) {
+			continue
+		}
+
+		// Assign the sorted environment variables, or the envFrom references, to the init containers and containers
+		injectedContainers, ok := injector.inject(source)
+		if !ok {
+			injector.skipEmpty(source, fails)
+			continue
+		}
+		for _, injected := range injectedContainers {
+			matchedContainers[injected.Name] = true
+			summary = append(summary, summaryRow{Kind: source.Kind, Workload: workloadName(source), Container: injected.Name, Count: injected.Count})
+		}
+
+		// Bump the images of the containers named with -image
+		for _, name := range overrideImages(source.Workload.PodSpec(), opts.images) {
+			matchedImages[name] = true
+		}
+
+		// Scale the workload to the -replicas of this environment
+		if opts.replicas >= 0 && !setReplicas(source.Workload, opts.replicas) {
+			debugf("%s %s has no replicas: ignoring -replicas\n", source.Kind, workloadName(source))
+		}
+
+		// Fill in the default requests and limits containers don't set
+		applyDefaultResources(source.Workload.PodSpec(), opts.defaultRequests, opts.defaultLimits)
+
+		// Rename the copy of the workload for -name-prefix and -name-suffix
+		renameWorkload(source, opts.namePrefix, opts.nameSuffix)
+
+		// Stamp the -annotate annotations on the workload itself
+		annotateWorkload(source, opts.annotations)
+
+		// -in-place writes every file back in the format it is in
+		format := opts.format
+		if opts.inPlace {
+			format = sourceFormat(source.File)
+		}
+
+		// Keep the document as it was before the rewrite to diff against
+		var originalData []byte
+		var err error
+		if opts.diff && source.Node != nil {
+			originalData, err = marshalDocument(source.Node, format)
+			if err != nil {
+				fails.write("Failed to marshal original %s: %v\n", source.Kind, err)
+				continue
+			}
+		}
+
+		// Order the containers by name for reproducible output
+		if opts.sortContainers {
+			sortContainers(source)
+		}
+
+		// Marshal the updated workload, rewriting only the env sections of the original document
+		updatedData, err := marshalWorkload(source, format)
+		if err != nil {
+			fails.write("Failed to marshal updated %s: %v\n", source.Kind, err)
+			continue
+		}
+
+		// Show what changed on stdout, which replaces the printed workloads of a dry run
+		if opts.diff {
+			fmt.Print(unifiedDiff(source.File, fmt.Sprintf("%s (updated %s %s)", source.File, source.Kind, workloadName(source)), originalData, updatedData))
+			if opts.dryRun {
+				continue
+			}
+		}
+
+		// Print the updated workload instead of writing anything, this is the only output on stdout.
+		// JSON documents are printed back to back since JSON has no comment for the header.
+		if opts.dryRun {
+			if format == "json" {
+				fmt.Printf("%s", updatedData)
+			} else {
+				fmt.Printf("--- # %s\n%s", workloadName(source), updatedData)
+			}
+			continue
+		}
+
+		// Collect the document to write back into its source file, which happens once the file's
+		// other workloads are updated as well
+		if opts.inPlace {
+			if inPlaceDocuments[source.File] == nil {
+				inPlaceDocuments[source.File] = make(map[int][]byte)
+				inPlaceFiles = append(inPlaceFiles, source)
+			}
+			inPlaceDocuments[source.File][source.Document] = updatedData
+			continue
+		}
+
+		// Collect the document for the combined output file instead of writing it on its own
+		if combineOutput {
+			combinedDocuments = append(combinedDocuments, updatedData)
+			combinedSources = append(combinedSources, source.File)
+			continue
+		}
+
+		// Keep the file's location relative to the workload directory
+		relDir := ""
+		if opts.preserveDirs {
+			relDir, err = filepath.Rel(opts.deployDir, filepath.Dir(source.File))
+			if err != nil {
+				fails.write("Failed to resolve relative path of %s: %v\n", source.File, err)
+				continue
+			}
+		}
+
+		// Write the updated workload YAML to a file named after the workload
+		outputFile, collidedWith, err := outputFileName(source, opts.outTemplate, opts.format, relDir, usedNames)
+		if err != nil {
+			fails.write("Failed to name the output file for %s %s: %v\n", source.Kind, workloadName(source), err)
+			continue
+		}
+		outputPath := filepath.Join(outputDir, relDir, outputFile)
+
+		// Two workloads would have been written to the same file, which -strict doesn't allow
+		if collidedWith != "" {
+			if opts.strict {
+				log.Fatalf("%s %s from %s has the same output file as a workload from %s", source.Kind, workloadName(source), source.File, collidedWith)
+			}
+			fails.warn("%s %s from %s has the same output file as a workload from %s: writing it to %s instead\n", source.Kind, workloadName(source), source.File, collidedWith, outputPath)
+		}
+
+		if relDir != "" {
+			err = os.MkdirAll(filepath.Dir(outputPath), 0755)
+			if err != nil {
+				fails.write("Failed to create output directory %s: %v\n", filepath.Dir(outputPath), err)
+				continue
+			}
+		}
+
+		updatedData = withGeneratedHeader(updatedData, opts.format)
+		if opts.documentStart {
+			updatedData = withDocumentStart(updatedData, opts.format)
+		}
+
+		// Compress the output like its source when asked to
+		if opts.gzip && source.Compressed {
+			updatedData, err = compress(updatedData)
+			if err != nil {
+				fails.write("Failed to compress updated %s: %v\n", source.Kind, err)
+				continue
+			}
+			outputPath += gzipExtension
+		}
+
+		// Leave an identical output file alone so repeated runs don't touch its mtime
+		if !opts.force && sameFileContent(outputPath, updatedData) {
+			infof("%s %s unchanged in %s\n", source.Kind, workloadName(source), outputPath)
+			continue
+		}
+
+		if !backUp(source.File) {
+			continue
+		}
+		err = writeFileAtomic(outputPath, updatedData, 0644)
+		if err != nil {
+			fails.write("Failed to write updated %s file %s: %v\n", source.Kind, outputPath, err)
+			continue
+		}
+
+		logFields{File: outputPath, Kind: source.Kind, Name: workloadName(source)}.infof("Updated %s saved to %s\n", source.Kind, outputPath)
+	}
+
+	// Rewrite the source files of -in-place, compressed again when they were
+	for _, source := range inPlaceFiles {
+		data, err := rewriteInPlace(source.File, inPlaceDocuments[source.File])
+		if err != nil {
+			fails.write("Failed to rewrite %s in place: %v\n", source.File, err)
+			continue
+		}
+		if opts.documentStart {
+			data = withDocumentStart(data, sourceFormat(source.File))
+		}
+		if source.Compressed {
+			data, err = compress(data)
+			if err != nil {
+				fails.write("Failed to compress %s: %v\n", source.File, err)
+				continue
+			}
+		}
+		if !opts.force && sameFileContent(source.File, data) {
+			infof("%s unchanged\n", source.File)
+			continue
+		}
+		if !backUp(source.File) {
+			continue
+		}
+		mode := os.FileMode(0644)
+		if info, err := os.Stat(source.File); err == nil {
+			mode = info.Mode().Perm()
+		}
+		err = writeFileAtomic(source.File, data, mode)
+		if err != nil {
+			fails.write("Failed to rewrite %s in place: %v\n", source.File, err)
+			continue
+		}
+		logFields{File: source.File}.infof("Updated %s in place\n", source.File)
+	}
+
+	// Write all updated workloads into a single multi-document file
+	if combineOutput && len(combinedDocuments) > 0 {
+		combinedData, err := combineDocuments(combinedDocuments, opts.format)
+		if err != nil {
+			log.Fatalf("Failed to combine updated workloads: %v", err)
+		}
+		combinedData = withGeneratedHeader(combinedData, opts.format)
+		if opts.documentStart {
+			combinedData = withDocumentStart(combinedData, opts.format)
+		}
+		if !opts.force && sameFileContent(combinedFile, combinedData) {
+			infof("Updated workloads unchanged in %s\n", combinedFile)
+		} else {
+			for _, file := range combinedSources {
+				backUp(file)
+			}
+			err = writeFileAtomic(combinedFile, combinedData, 0644)
+			if err != nil {
+				log.Fatalf("Failed to write combined output file %s: %v", combinedFile, err)
+			}
+			infof("Updated workloads saved to %s\n", combinedFile)
+		}
+	}
+
+	// A -containers name that matched nothing is most likely a typo
+	for name := range opts.containers {
+		if !matchedContainers[name] {
+			warnf("-containers %s did not match any container\n", name)
+		}
+	}
+
+	if opts.imageMatch != "" && len(matchedContainers) == 0 {
+		warnf("-image-match %s did not match any container\n", opts.imageMatch)
+	}
+	if opts.marker != "" && len(matchedContainers) == 0 {
+		warnf("No container declares the -marker env var %s\n", opts.marker)
+	}
+
+	// An -image name that matched nothing is most likely a typo
+	for name := range opts.images {
+		if !matchedImages[name] {
+			warnf("-image %s did not match any container\n", name)
+		}
+	}
+
+	return summary
+}
+
+// marshalWorkload writes the workload's name and labels, replicas, container images, env vars, envFrom entries, default
+// resources and pod template annotations into its document node and marshals the node in the
+// given format, so comments, field order and fields the workload structs don't model are kept
+func marshalWorkload(source SourceWorkload, format string) ([]byte, error) {
+	if source.Node == nil {
+		source.Node = &yaml.Node{}
+		err := source.Node.Encode(source.Workload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	podSpec := source.Workload.PodSpec()
+	podSpecNode := lookupNode(source.Node, podSpecPath(source.Kind)...)
+	podSpecContainers := map[string][]Container{
+		"initContainers": podSpec.InitContainers,
+		"containers":     podSpec.Containers,
+	}
+	for field, containers := range podSpecContainers {
+		containerNodes := lookupNode(podSpecNode, field)
+		if containerNodes == nil || containerNodes.Kind != yaml.SequenceNode {
+			continue
+		}
+		for i, containerNode := range containerNodes.Content {
+			if i >= len(containers) {
+				break
+			}
+			if image := mappingValue(containerNode, "image"); containers[i].Image != "" && (image == nil || image.Value != containers[i].Image) {
+				err := setNodeValue(containerNode, "image", containers[i].Image)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			// An empty env is dropped rather than written as env: []. Containers left alone, such as
+			// the ones outside -containers, keep their env exactly as written, comments included.
+			if len(containers[i].Env) == 0 {
+				removeNodeKey(containerNode, "env")
+			} else if !sameNodeValue(containerNode, "env", containers[i].Env) {
+				err := setNodeValue(containerNode, "env", containers[i].Env)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if containers[i].Resources != nil {
+				err := addMissingResources(containerNode, "requests", containers[i].Resources.Requests)
+				if err == nil {
+					err = addMissingResources(containerNode, "limits", containers[i].Resources.Limits)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			if (len(containers[i].EnvFrom) > 0 || mappingValue(containerNode, "envFrom") != nil) && !sameNodeValue(containerNode, "envFrom", containers[i].EnvFrom) {
+				err := setNodeValue(containerNode, "envFrom", containers[i].EnvFrom)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if podSpecNode != nil && len(podSpec.ImagePullSecrets) > 0 && !sameNodeValue(podSpecNode, "imagePullSecrets", podSpec.ImagePullSecrets) {
+		err := setNodeValue(podSpecNode, "imagePullSecrets", podSpec.ImagePullSecrets)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if replicas := replicasOf(source.Workload); replicas != nil {
+		current := lookupNode(source.Node, "spec", "replicas")
+		if current == nil || current.Value != strconv.Itoa(*replicas) {
+			specNode, err := mappingChild(source.Node, "spec")
+			if err != nil {
+				return nil, err
+			}
+			err = setNodeValue(specNode, "replicas", *replicas)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	templateNode := lookupNode(source.Node, podTemplatePath(source.Kind)...)
+	if templateNode != nil {
+		err := writeAnnotations(templateNode, source.Workload.Template().Metadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := writeAnnotations(source.Node, source.Workload.ObjectMeta())
+	if err != nil {
+		return nil, err
+	}
+	err = writeNames(source, templateNode)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalDocument(source.Node, format)
+}
+
+// marshalDocument marshals a document node as YAML or JSON
+func marshalDocument(node *yaml.Node, format string) ([]byte, error) {
+	if format == "json" {
+		return marshalNodeJSON(node)
+	}
+	return marshalNode(node)
+}
+
+// addMissingResources writes the quantities absent from a container's resources.requests or
+// resources.limits, leaving the ones already written untouched
+func addMissingResources(containerNode *yaml.Node, field string, quantities map[string]string) error {
+	names := make([]string, 0, len(quantities))
+	for name := range quantities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if lookupNode(containerNode, "resources", field, name) != nil {
+			continue
+		}
+		fieldNode, err := mappingChild(containerNode, "resources", field)
+		if err != nil {
+			return err
+		}
+		err = setNodeValue(fieldNode, name, quantities[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameNodeValue reports whether the value stored under key in a mapping node decodes to want
+func sameNodeValue[T any](node *yaml.Node, key string, want []T) bool {
+	existing := mappingValue(node, key)
+	if existing == nil {
+		return len(want) == 0
+	}
+	var current []T
+	if err := existing.Decode(&current); err != nil {
+		return false
+	}
+	if len(current) == 0 && len(want) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(current, want)
+}
+
+// combineDocuments joins marshaled workloads into one file: a multi-document YAML stream,
+// or for JSON a v1 List holding every workload as an item
+func combineDocuments(documents [][]byte, format string) ([]byte, error) {
+	if format != "json" {
+		return bytes.Join(documents, []byte("---\n")), nil
+	}
+
+	items := make([]json.RawMessage, len(documents))
+	for i, document := range documents {
+		items[i] = document
+	}
+	list := struct {
+		APIVersion string            `json:"apiVersion"`
+		Kind       string            `json:"kind"`
+		Items      []json.RawMessage `json:"items"`
+	}{APIVersion: "v1", Kind: "List", Items: items}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// generatedHeader starts every YAML output file, telling people the file is rewritten on every run.
+// It never changes, so it doesn't show up in diffs between runs.
+const generatedHeader = "# Generated by env-deployment-k8s — do not edit\n"
+
+// withGeneratedHeader puts the generatedHeader comment in front of a YAML file that doesn't have it
+// already, which happens when an output file is read back as input. JSON has no comments and is returned as is.
+func withGeneratedHeader(data []byte, format string) []byte {
+	if format == "json" || hasGeneratedHeader(data) {
+		return data
+	}
+	return append([]byte(generatedHeader), data...)
+}
+
+// hasGeneratedHeader reports whether a file starts with the generatedHeader, possibly after the --- marker
+func hasGeneratedHeader(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimPrefix(data, []byte("---\n")), []byte(generatedHeader))
+}
+
+// withDocumentStart puts the --- document marker in front of a YAML file that doesn't start with
+// one already. JSON has no such marker and is returned as is.
+func withDocumentStart(data []byte, format string) []byte {
+	if format == "json" || bytes.HasPrefix(data, []byte("---\n")) {
+		return data
+	}
+	return append([]byte("---\n"), data...)
+}
+
+// workloadName returns the workload's metadata.name, falling back to the source filename.
+// parseFile already reported the missing name.
+func workloadName(source SourceWorkload) string {
+	name, ok := injector.MetadataString(source.Workload.ObjectMeta(), "name")
+	if !ok {
+		name = strings.TrimSuffix(filepath.Base(source.File), filepath.Ext(source.File))
+	}
+	return name
+}
+
+// defaultOutTemplate names outputs <name>_updated.<format>, adding the kind for anything other than a Deployment
+const defaultOutTemplate = `{{.Name}}{{if ne .Kind "Deployment"}}-{{lower .Kind}}{{end}}_updated.{{.Format}}`
+
+// outputNameData holds the variables available to -out-template
+type outputNameData struct {
+	Name      string
+	Namespace string
+	Kind      string
+	Format    string
+}
+
+// parseOutTemplate parses an -out-template and renders it once with sample values, so a template
+// referring to an unknown variable fails before any file is processed
+func parseOutTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("out").Funcs(template.FuncMap{
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+	}).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	_, err = renderOutputName(tmpl, outputNameData{Name: "app", Namespace: "default", Kind: "Deployment", Format: "yaml"})
+	if err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// renderOutputName executes the output filename template, which must produce a plain filename
+func renderOutputName(tmpl *template.Template, data outputNameData) (string, error) {
+	var name bytes.Buffer
+	err := tmpl.Execute(&name, data)
+	if err != nil {
+		return "", err
+	}
+	if name.Len() == 0 || strings.ContainsAny(name.String(), `/\`) {
+		return "", fmt.Errorf("output filename %q must be a non-empty name without path separators", name.String())
+	}
+	return name.String(), nil
+}
+
+// outputFileName renders the -out-template for the workload. On collisions it renders again with
+// a numeric suffix on the name, or puts the suffix before the extension when the template doesn't use the name.
+// usedNames maps every output path within the output directory, dir joined with the name, to the source
+// file that claimed it, and the source file of a colliding workload is returned so it can be reported.
+func outputFileName(source SourceWorkload, tmpl *template.Template, format, dir string, usedNames map[string]string) (string, string, error) {
+	name := workloadName(source)
+	data := outputNameData{Name: name, Namespace: namespaceOf(source.Workload.ObjectMeta()), Kind: source.Kind, Format: format}
+	base, err := renderOutputName(tmpl, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	collidedWith := usedNames[filepath.Join(dir, base)]
+	candidate := base
+	for i := 2; usedNames[filepath.Join(dir, candidate)] != ""; i++ {
+		data.Name = fmt.Sprintf("%s-%d", name, i)
+		candidate, err = renderOutputName(tmpl, data)
+		if err != nil {
+			return "", "", err
+		}
+		if candidate == base {
+			ext := filepath.Ext(base)
+			candidate = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(base, ext), i, ext)
+		}
+	}
+	usedNames[filepath.Join(dir, candidate)] = source.File
+
+	return candidate, collidedWith, nil
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"env-deployment-k8s/pkg/injector"
+	"gopkg.in/yaml.v3"
+)
+
+func secretEnv(name, secretName, key string) EnvVar {
+	return EnvVar{
+		Name:      name,
+		ValueFrom: &ValueFromRef{SecretKeyRef: &SecretKeyRef{Name: secretName, Key: key}},
+	}
+}
+
+func TestMarshalWorkloadKeepsComments(t *testing.T) {
+	input := `# managed by team
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: payments # inline
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+        - name: app
+          image: nginx
+          env:
+            - name: OLD
+`
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(input), &node); err != nil {
+		t.Fatal(err)
+	}
+	var dep Deployment
+	if err := node.Decode(&dep); err != nil {
+		t.Fatal(err)
+	}
+
+	injector.InjectEnv(&dep, []EnvVar{secretEnv("API_KEY", "app-secret", "api_key")}, false, injector.ContainerSelector{})
+	out, err := marshalWorkload(SourceWorkload{Kind: "Deployment", Workload: &dep, Node: &node}, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(out)
+	for _, want := range []string{"# managed by team", "name: payments # inline", "replicas: 3", "name: API_KEY"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "OLD") {
+		t.Errorf("output still contains the replaced env var:\n%s", got)
+	}
+}
+
+func TestMarshalWorkloadKeepsUnknownFields(t *testing.T) {
+	input := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: payments
+spec:
+  replicas: 3
+  strategy:
+    type: RollingUpdate
+  template:
+    spec:
+      containers:
+        - name: app
+          image: nginx
+          resources:
+            limits:
+              cpu: 100m
+          volumeMounts:
+            - name: data
+              mountPath: /data
+          env:
+            - name: LOG_LEVEL
+              value: debug
+            - name: POD_NAME
+              valueFrom:
+                fieldRef:
+                  fieldPath: metadata.name
+`
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(input), &node); err != nil {
+		t.Fatal(err)
+	}
+	var dep Deployment
+	if err := node.Decode(&dep); err != nil {
+		t.Fatal(err)
+	}
+
+	injector.InjectEnv(&dep, []EnvVar{secretEnv("API_KEY", "app-secret", "api_key")}, true, injector.ContainerSelector{})
+	out, err := marshalWorkload(SourceWorkload{Kind: "Deployment", Workload: &dep, Node: &node}, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(out)
+	for _, want := range []string{
+		"replicas: 3",
+		"type: RollingUpdate",
+		"cpu: 100m",
+		"mountPath: /data",
+		"value: debug",
+		"fieldPath: metadata.name",
+		"name: API_KEY",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "valueFrom: null") {
+		t.Errorf("output contains an empty valueFrom:\n%s", got)
+	}
+}
+
+func TestMarshalWorkloadJSON(t *testing.T) {
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: payments\nspec:\n  replicas: 3\n"), &node); err != nil {
+		t.Fatal(err)
+	}
+	var dep Deployment
+	if err := node.Decode(&dep); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := marshalWorkload(SourceWorkload{Kind: "Deployment", Workload: &dep, Node: &node}, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, out)
+	}
+	if got["apiVersion"] != "apps/v1" || got["spec"].(map[string]interface{})["replicas"] != float64(3) {
+		t.Errorf("unexpected JSON output:\n%s", out)
+	}
+}
+
+func TestMarshalWorkloadOmitsEmptyEnv(t *testing.T) {
+	var node yaml.Node
+	input := "apiVersion: apps/v1\nkind: Deployment\nspec:\n  template:\n    spec:\n      containers:\n        - name: app\n          env:\n            - name: OLD\n        - name: sidecar\n"
+	if err := yaml.Unmarshal([]byte(input), &node); err != nil {
+		t.Fatal(err)
+	}
+	var dep Deployment
+	if err := node.Decode(&dep); err != nil {
+		t.Fatal(err)
+	}
+
+	injector.InjectEnv(&dep, nil, false, injector.ContainerSelector{})
+	out, err := marshalWorkload(SourceWorkload{Kind: "Deployment", Workload: &dep, Node: &node}, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "env") {
+		t.Errorf("output still has an env key:\n%s", out)
+	}
+}
+
+func TestMarshalWorkloadCronJob(t *testing.T) {
+	var node yaml.Node
+	input := "apiVersion: batch/v1\nkind: CronJob\nmetadata:\n  name: nightly\nspec:\n  schedule: \"0 2 * * *\"\n  jobTemplate:\n    spec:\n      template:\n        spec:\n          containers:\n            - name: job\n              image: busybox\n"
+	if err := yaml.Unmarshal([]byte(input), &node); err != nil {
+		t.Fatal(err)
+	}
+	w, err := decodeWorkload("CronJob", &node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	injector.InjectEnv(w, []EnvVar{secretEnv("API_KEY", "app-secret", "api_key")}, false, injector.ContainerSelector{})
+	out, err := marshalWorkload(SourceWorkload{Kind: "CronJob", Workload: w, Node: &node}, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cronJob CronJob
+	if err := yaml.Unmarshal(out, &cronJob); err != nil {
+		t.Fatal(err)
+	}
+	env := cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Env
+	if len(env) != 1 || env[0].Name != "API_KEY" {
+		t.Errorf("CronJob container env = %+v, want API_KEY:\n%s", env, out)
+	}
+}
+
+func TestMarshalWorkloadPod(t *testing.T) {
+	var node yaml.Node
+	input := "apiVersion: v1\nkind: Pod\nmetadata:\n  name: debug\nspec:\n  containers:\n    - name: shell\n      image: busybox\n"
+	if err := yaml.Unmarshal([]byte(input), &node); err != nil {
+		t.Fatal(err)
+	}
+	w, err := decodeWorkload("Pod", &node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	injector.InjectEnv(w, []EnvVar{secretEnv("API_KEY", "app-secret", "api_key")}, false, injector.ContainerSelector{})
+	out, err := marshalWorkload(SourceWorkload{Kind: "Pod", Workload: w, Node: &node}, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pod Pod
+	if err := yaml.Unmarshal(out, &pod); err != nil {
+		t.Fatal(err)
+	}
+	env := pod.Spec.Containers[0].Env
+	if len(env) != 1 || env[0].Name != "API_KEY" {
+		t.Errorf("Pod container env = %+v, want API_KEY:\n%s", env, out)
+	}
+	if name, _ := injector.MetadataString(pod.Metadata, "name"); name != "debug" {
+		t.Errorf("Pod name = %q, want debug:\n%s", name, out)
+	}
+}
+
+func TestOutputFileName(t *testing.T) {
+	workload := func(kind, name, namespace string) SourceWorkload {
+		metadata := map[string]interface{}{"name": name}
+		if namespace != "" {
+			metadata["namespace"] = namespace
+		}
+		return SourceWorkload{File: name + ".yaml", Kind: kind, Workload: &Deployment{Metadata: metadata}}
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		sources  []SourceWorkload
+		want     []string
+	}{
+		{
+			"default",
+			defaultOutTemplate,
+			[]SourceWorkload{workload("Deployment", "api", ""), workload("Deployment", "api", ""), workload("CronJob", "nightly", "")},
+			[]string{"api_updated.yaml", "api-2_updated.yaml", "nightly-cronjob_updated.yaml"},
+		},
+		{
+			"namespace",
+			"{{.Namespace}}-{{.Name}}.{{.Format}}",
+			[]SourceWorkload{workload("Deployment", "payments", "staging"), workload("Deployment", "payments", "")},
+			[]string{"staging-payments.yaml", "default-payments.yaml"},
+		},
+		{
+			"without name",
+			"{{.Kind}}.yaml",
+			[]SourceWorkload{workload("Deployment", "a", ""), workload("Deployment", "b", "")},
+			[]string{"Deployment.yaml", "Deployment-2.yaml"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseOutTemplate(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			usedNames := make(map[string]string)
+			for i, source := range tt.sources {
+				got, _, err := outputFileName(source, tmpl, "yaml", "", usedNames)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want[i] {
+					t.Errorf("outputFileName() = %s, want %s", got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOutputFileNameCollision(t *testing.T) {
+	tmpl, err := parseOutTemplate(defaultOutTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workload := func(file string) SourceWorkload {
+		return SourceWorkload{File: file, Kind: "Deployment", Workload: &Deployment{Metadata: map[string]interface{}{"name": "api"}}}
+	}
+
+	usedNames := make(map[string]string)
+	if _, collidedWith, _ := outputFileName(workload("team-a/api.yaml"), tmpl, "yaml", "", usedNames); collidedWith != "" {
+		t.Errorf("first workload collided with %s", collidedWith)
+	}
+	got, collidedWith, err := outputFileName(workload("team-b/api.yaml"), tmpl, "yaml", "", usedNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "api-2_updated.yaml" || collidedWith != "team-a/api.yaml" {
+		t.Errorf("outputFileName() = %s colliding with %q, want api-2_updated.yaml colliding with team-a/api.yaml", got, collidedWith)
+	}
+
+	// The same name in another directory of -preserve-dirs is not a collision
+	if got, collidedWith, _ := outputFileName(workload("team-c/api.yaml"), tmpl, "yaml", "team-c", usedNames); got != "api_updated.yaml" || collidedWith != "" {
+		t.Errorf("outputFileName() in another directory = %s colliding with %q, want api_updated.yaml", got, collidedWith)
+	}
+}
+
+func TestParseOutTemplateInvalid(t *testing.T) {
+	for _, text := range []string{"{{.Name", "{{.Cluster}}.yaml", "{{.Namespace}}/{{.Name}}.yaml"} {
+		if _, err := parseOutTemplate(text); err == nil {
+			t.Errorf("parseOutTemplate(%q) expected an error", text)
+		}
+	}
+}
+
+func TestMarshalWorkloadExpandsAliases(t *testing.T) {
+	input := "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\nspec:\n  template:\n    spec:\n      containers:\n        - &base\n          name: app\n          env: &env\n            - name: SHARED\n        - name: sidecar\n          env: *env\n        - *base\n"
+
+	m := &Manifests{}
+	m.parseFile("deployment.yaml", []byte(input), &options{}, newFailures(false))
+	if len(m.Workloads) != 1 {
+		t.Fatalf("parseFile() found %d workloads, want 1", len(m.Workloads))
+	}
+	source := m.Workloads[0]
+
+	injector.InjectEnv(source.Workload, []EnvVar{secretEnv("API_KEY", "app-secret", "api_key")}, true, injector.ContainerSelector{Names: map[string]bool{"sidecar": true}})
+	out, err := marshalWorkload(source, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dep Deployment
+	if err := yaml.Unmarshal(out, &dep); err != nil {
+		t.Fatalf("output is not valid YAML: %v\n%s", err, out)
+	}
+	for _, container := range dep.Spec.Template.Spec.Containers {
+		wantEnv := 1
+		if container.Name == "sidecar" {
+			wantEnv = 2
+		}
+		if len(container.Env) != wantEnv {
+			t.Errorf("container %s has %d env vars, want %d:\n%s", container.Name, len(container.Env), wantEnv, out)
+		}
+	}
+	if strings.Contains(string(out), "*") || strings.Contains(string(out), "&") {
+		t.Errorf("output still has anchors or aliases:\n%s", out)
+	}
+}
+
+func TestWithDocumentStart(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format string
+		want   string
+	}{
+		{"yaml", "kind: Deployment\n", "yaml", "---\nkind: Deployment\n"},
+		{"already marked", "---\nkind: Deployment\n", "yaml", "---\nkind: Deployment\n"},
+		{"json", "{}\n", "json", "{}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(withDocumentStart([]byte(tt.data), tt.format)); got != tt.want {
+				t.Errorf("withDocumentStart() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithGeneratedHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format string
+		want   string
+	}{
+		{"yaml", "kind: Deployment\n", "yaml", generatedHeader + "kind: Deployment\n"},
+		{"already headed", generatedHeader + "kind: Deployment\n", "yaml", generatedHeader + "kind: Deployment\n"},
+		{"after document start", "---\n" + generatedHeader + "kind: Deployment\n", "yaml", "---\n" + generatedHeader + "kind: Deployment\n"},
+		{"json", "{}\n", "json", "{}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(withGeneratedHeader([]byte(tt.data), tt.format)); got != tt.want {
+				t.Errorf("withGeneratedHeader() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalWorkloadReplicas(t *testing.T) {
+	input := "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\nspec:\n  replicas: 3 # prod\n  template:\n    spec:\n      containers:\n        - name: app\n"
+	parse := func() SourceWorkload {
+		var node yaml.Node
+		if err := yaml.Unmarshal([]byte(input), &node); err != nil {
+			t.Fatal(err)
+		}
+		w, err := decodeWorkload("Deployment", &node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return SourceWorkload{Kind: "Deployment", Workload: w, Node: &node}
+	}
+
+	out, err := marshalWorkload(parse(), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "replicas: 3 # prod") {
+		t.Errorf("output lost the replicas:\n%s", out)
+	}
+
+	source := parse()
+	if !setReplicas(source.Workload, 5) {
+		t.Fatal("setReplicas() = false for a Deployment")
+	}
+	out, err = marshalWorkload(source, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "replicas: 5") {
+		t.Errorf("output does not have the new replicas:\n%s", out)
+	}
+
+	if setReplicas(&DaemonSet{}, 5) {
+		t.Error("setReplicas() = true for a DaemonSet")
+	}
+}
+
+func TestWorkloadNameFallback(t *testing.T) {
+	source := SourceWorkload{Kind: "Deployment", File: "manifests/api.yaml", Workload: &Deployment{Metadata: map[string]interface{}{}}}
+
+	var name string
+	entries := captureJSONLogs(t, func() { name = workloadName(source) })
+	if name != "api" {
+		t.Errorf("workloadName() = %s, want api", name)
+	}
+	if len(entries) != 0 {
+		t.Errorf("workloadName() logged %+v, want nothing", entries)
+	}
+}
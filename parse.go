@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"env-deployment-k8s/pkg/injector"
+	"gopkg.in/yaml.v3"
+)
+
+// errMissingType is returned by classify for documents without a string apiVersion and kind
+var errMissingType = errors.New("does not have valid apiVersion or kind")
+
+// Manifests holds the resources found in the input files
+type Manifests struct {
+	Secrets    []Secret
+	ConfigMaps []ConfigMap
+	Workloads  []SourceWorkload
+}
+
+// parseFiles reads and parses the files with a pool of opts.workers goroutines. The results
+// are combined in file order so the outcome doesn't depend on which worker finished first.
+// Once ctx is done no more files are started, the ones not reached are recorded as skipped.
+func parseFiles(ctx context.Context, files []string, opts *options, fails *failures) *Manifests {
+	results := make([]*Manifests, len(files))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < opts.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = parseInputFile(files[i], opts, fails)
+			}
+		}()
+	}
+	started := 0
+	for started < len(files) && ctx.Err() == nil {
+		select {
+		case jobs <- started:
+			started++
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if started < len(files) {
+		errorf("Timed out: skipping %d files not yet parsed\n", len(files)-started)
+		for _, file := range files[started:] {
+			fails.timeout(file, "", "")
+		}
+	}
+
+	manifests := &Manifests{}
+	for _, result := range results[:started] {
+		manifests.Secrets = append(manifests.Secrets, result.Secrets...)
+		manifests.ConfigMaps = append(manifests.ConfigMaps, result.ConfigMaps...)
+		manifests.Workloads = append(manifests.Workloads, result.Workloads...)
+	}
+	return manifests
+}
+
+// parseInputFile reads a single file and collects the resources it contains
+func parseInputFile(file string, opts *options, fails *failures) *Manifests {
+	debugf("Processing file: %s\n", file)
+
+	manifests := &Manifests{}
+
+	// Leave out files too large to be a manifest before reading them into memory
+	if opts.maxFileSize > 0 {
+		info, err := os.Stat(file)
+		if err != nil {
+			fails.parse(file, "Failed to read file %s: %v\n", file, err)
+			return manifests
+		}
+		if info.Size() > opts.maxFileSize {
+			fails.warn("File %s is %d bytes, larger than -max-file-size %d: skipping\n", file, info.Size(), opts.maxFileSize)
+			fails.skip(file, "", "", fmt.Sprintf("larger than -max-file-size %d bytes", opts.maxFileSize))
+			return manifests
+		}
+	}
+
+	// Read the YAML file, unpacking it when it is gzip-compressed
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fails.parse(file, "Failed to read file %s: %v\n", file, err)
+		return manifests
+	}
+	data, compressed, err := decompress(data)
+	if err != nil {
+		fails.parse(file, "Failed to decompress file %s: %v\n", file, err)
+		return manifests
+	}
+
+	// Output an earlier run wrote beside the inputs is not read back in as input
+	if hasGeneratedHeader(normalizeInput(data)) {
+		debugf("Skipping generated file: %s\n", file)
+		return manifests
+	}
+
+	manifests.parseFile(file, data, opts, fails)
+	for i := range manifests.Workloads {
+		manifests.Workloads[i].Compressed = compressed
+	}
+	return manifests
+}
+
+// supportedAPIVersions maps every kind the tool processes to the apiVersion it expects
+var supportedAPIVersions = map[string]string{
+	"Secret":      "v1",
+	"ConfigMap":   "v1",
+	"Deployment":  "apps/v1",
+	"StatefulSet": "apps/v1",
+	"DaemonSet":   "apps/v1",
+	"ReplicaSet":  "apps/v1",
+	"Pod":         "v1",
+	"Job":         "batch/v1",
+	"CronJob":     "batch/v1",
+}
+
+// stdinName stands in for the filename of documents read from stdin
+const stdinName = "<stdin>"
+
+// parseStdin reads a multi-document stream from stdin and parses it like a single file
+func parseStdin(opts *options, fails *failures) *Manifests {
+	debugf("Processing stdin\n")
+
+	manifests := &Manifests{}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fails.parse(stdinName, "Failed to read stdin: %v\n", err)
+		return manifests
+	}
+	data, _, err = decompress(data)
+	if err != nil {
+		fails.parse(stdinName, "Failed to decompress stdin: %v\n", err)
+		return manifests
+	}
+
+	manifests.parseFile(stdinName, data, opts, fails)
+	return manifests
+}
+
+// loadSecretFile reads the Secret given with a flag such as -secret. Anything other than a file holding exactly
+// one valid Secret is a hard error, since the run would otherwise silently use the wrong Secret.
+func loadSecretFile(flagName, file string, opts *options) Secret {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatalf("Failed to read %s file %s: %v", flagName, file, err)
+	}
+	data, _, err = decompress(data)
+	if err != nil {
+		log.Fatalf("Failed to decompress %s file %s: %v", flagName, file, err)
+	}
+
+	// Warnings are printed as for any input and only stop the run under -strict, errors always do
+	fails := newFailures(false)
+	manifests := &Manifests{}
+	manifests.parseFile(file, data, opts, fails)
+	problems := fails.errors
+	if opts.strict {
+		problems = append(problems, fails.warnings...)
+	}
+	if len(problems) > 0 {
+		log.Fatalf("Invalid %s file %s: %s", flagName, file, strings.Join(problems, "; "))
+	}
+	if len(manifests.Secrets) != 1 {
+		log.Fatalf("%s file %s must contain exactly one valid Secret, found %d", flagName, file, len(manifests.Secrets))
+	}
+	return manifests.Secrets[0]
+}
+
+// normalizeInput strips a leading UTF-8 byte order mark and turns CRLF line endings into LF,
+// as left behind by editors on Windows
+func normalizeInput(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+}
+
+// classify returns the kind and apiVersion of a single YAML document.
+// An empty document yields an empty kind and no error.
+func classify(data []byte) (kind, apiVersion string, err error) {
+	var node yaml.Node
+	err = yaml.Unmarshal(data, &node)
+	if err != nil {
+		return "", "", err
+	}
+	return classifyNode(&node)
+}
+
+// classifyNode is classify for a document that has already been decoded into a node
+func classifyNode(node *yaml.Node) (kind, apiVersion string, err error) {
+	// Unmarshal the YAML document into a generic map
+	var genericYaml map[string]interface{}
+	err = node.Decode(&genericYaml)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Empty documents, such as a trailing ---, have nothing to classify
+	if genericYaml == nil {
+		return "", "", nil
+	}
+
+	apiVersion, apiVersionOk := genericYaml["apiVersion"].(string)
+	kind, kindOk := genericYaml["kind"].(string)
+	if !apiVersionOk || !kindOk {
+		return "", "", errMissingType
+	}
+
+	return kind, apiVersion, nil
+}
+
+// yamlLinePattern finds the line number the yaml library puts in its errors
+var yamlLinePattern = regexp.MustCompile(`line (\d+): `)
+
+// yamlErrorLine splits a yaml library error into the line it is about, or fallback when it doesn't say,
+// and the rest of the message
+func yamlErrorLine(err error, fallback int) (int, string) {
+	var typeErr *yaml.TypeError
+	msg := err.Error()
+	if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+		msg = strings.Join(typeErr.Errors, "; ")
+	}
+	msg = strings.TrimPrefix(msg, "yaml: ")
+
+	match := yamlLinePattern.FindStringSubmatchIndex(msg)
+	if match == nil {
+		return fallback, msg
+	}
+	line, _ := strconv.Atoi(msg[match[2]:match[3]])
+	return line, msg[:match[0]] + msg[match[1]:]
+}
+
+// documentLine returns the line a document's content starts on
+func documentLine(node *yaml.Node) int {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		return node.Content[0].Line
+	}
+	return node.Line
+}
+
+// parseFile decodes every document in a file, since manifests may be separated by ---,
+// and collects the Secrets, ConfigMaps and workloads it contains
+func (m *Manifests) parseFile(file string, data []byte, opts *options, fails *failures) {
+	// A template that rendered to nothing leaves an empty file, which is nothing to worry about
+	data = normalizeInput(data)
+	if len(bytes.TrimSpace(data)) == 0 {
+		logFields{File: file}.infof("Skipping empty file %s\n", file)
+		fails.skip(file, "", "", "empty file")
+		return
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for doc := 1; ; doc++ {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			line, msg := yamlErrorLine(err, 0)
+			fails.parseAt(file, line, "Failed to parse YAML document %d: %s\n", doc, msg)
+			return
+		}
+
+		// Determine if the document is a Secret, a ConfigMap or a workload
+		kind, apiVersion, err := classifyNode(&node)
+		if errors.Is(err, errMissingType) {
+			logFields{File: file}.errorf("Document %d in file %s %v: skipping\n", doc, file, err)
+			fails.skip(file, "", "", fmt.Sprintf("document %d %v", doc, err))
+			continue
+		}
+		if err != nil {
+			line, msg := yamlErrorLine(err, documentLine(&node))
+			fails.parseAt(file, line, "Failed to parse YAML document %d: %s\n", doc, msg)
+			continue
+		}
+		if kind == "" {
+			continue
+		}
+
+		// Only known kinds under their expected apiVersion are processed. A known kind under another
+		// apiVersion is usually a deprecated one such as extensions/v1beta1, which deserves a clear warning.
+		expected, ok := supportedAPIVersions[kind]
+		if !ok {
+			logFields{File: file, Kind: kind}.infof("Document %d in file %s is not a supported kind: skipping\n", doc, file)
+			fails.skip(file, kind, "", fmt.Sprintf("document %d is not a supported kind", doc))
+			continue
+		}
+		if apiVersion != expected {
+			fails.noteFor(logFields{File: file, Kind: kind}, "%s in document %d of file %s has apiVersion %s, expected %s: skipping\n", kind, doc, file, apiVersion, expected)
+			fails.skip(file, kind, "", fmt.Sprintf("document %d has apiVersion %s, expected %s", doc, apiVersion, expected))
+			continue
+		}
+
+		// Catch misspelled fields the structs would silently drop
+		if opts.schemaValidate {
+			for _, problem := range schemaProblems(&node, kind) {
+				fails.warn("%s in document %d of file %s, %s\n", kind, doc, file, problem)
+			}
+		}
+
+		// Process based on kind
+		switch kind {
+		case "Secret":
+			var sec Secret
+			err := node.Decode(&sec)
+			if err != nil {
+				line, msg := yamlErrorLine(err, documentLine(&node))
+				fails.parseAt(file, line, "Failed to parse Secret: %s\n", msg)
+				continue
+			}
+
+			sec.Keys = append(mappingKeys(lookupNode(&node, "data")), mappingKeys(lookupNode(&node, "stringData"))...)
+
+			// Env vars reference the Secret by name, so a Secret without one can't be used
+			secretName, ok := injector.MetadataString(sec.Metadata, "name")
+			if !ok {
+				fails.parseAt(file, documentLine(&node), "Secret has no valid metadata.name: skipping it\n")
+				continue
+			}
+
+			// Typed Secrets such as TLS keys or registry credentials are for Kubernetes, not the containers
+			if injector.PullSecret(&sec) {
+				fails.noteFor(logFields{File: file, Kind: kind, Name: secretName}, "Secret %s in file %s has type %s: adding it to imagePullSecrets instead of injecting its keys\n", secretName, file, sec.Type)
+			} else if !injector.EnvSecret(&sec) {
+				fails.noteFor(logFields{File: file, Kind: kind, Name: secretName}, "Secret %s in file %s has type %s: not injecting its keys\n", secretName, file, sec.Type)
+			}
+			// An empty Secret usually failed to render upstream and would leave every workload without its env
+			if len(sec.Data) == 0 && len(sec.StringData) == 0 && opts.failOnEmptySecret {
+				log.Fatalf("Secret %s in file %s has no data or stringData keys", secretName, file)
+			}
+			if len(sec.Data) == 0 && len(sec.StringData) == 0 {
+				fails.noteFor(logFields{File: file, Kind: kind, Name: secretName}, "Secret %s in file %s has no data or stringData keys\n", secretName, file)
+			}
+
+			// Values under data must be base64, a common mistake is putting plain text there instead of stringData
+			invalidKeys := invalidBase64Keys(sec.Data)
+			for _, key := range invalidKeys {
+				if opts.validateSecrets {
+					// A key merged in with << has no node of its own under data
+					line := documentLine(&node)
+					if keyNode := lookupNode(&node, "data", key); keyNode != nil {
+						line = keyNode.Line
+					}
+					fails.parseAt(file, line, "Secret data key %s is not valid base64\n", key)
+				} else {
+					fails.noteFor(logFields{File: file, Kind: kind, Name: secretName}, "Secret data key %s in file %s is not valid base64\n", key, file)
+				}
+			}
+			if opts.validateSecrets && len(invalidKeys) > 0 {
+				continue
+			}
+
+			// A value that decodes to nothing is usually a bad paste rather than an intentionally empty key
+			if opts.validateSecrets {
+				for _, key := range emptyDecodedKeys(sec.Data) {
+					fails.noteFor(logFields{File: file, Kind: kind, Name: secretName}, "Secret data key %s in file %s decodes to an empty value\n", key, file)
+				}
+			}
+
+			m.Secrets = append(m.Secrets, sec)
+			debugf("Valid Secret found in file %s\n", file)
+
+		case "ConfigMap":
+			var cm ConfigMap
+			err := node.Decode(&cm)
+			if err != nil {
+				line, msg := yamlErrorLine(err, documentLine(&node))
+				fails.parseAt(file, line, "Failed to parse ConfigMap: %s\n", msg)
+				continue
+			}
+			cm.Keys = mappingKeys(lookupNode(&node, "data"))
+			if _, ok := injector.MetadataString(cm.Metadata, "name"); !ok {
+				fails.parseAt(file, documentLine(&node), "ConfigMap has no valid metadata.name: skipping it\n")
+				continue
+			}
+			m.ConfigMaps = append(m.ConfigMaps, cm)
+			debugf("Valid ConfigMap found in file %s\n", file)
+
+		default:
+			// The document is rewritten in place, so shared anchors are expanded into separate copies first
+			if expandAliases(&node) {
+				debugf("Expanded YAML aliases in %s in file %s\n", kind, file)
+			}
+			w, err := decodeWorkload(kind, &node)
+			if err != nil {
+				line, msg := yamlErrorLine(err, documentLine(&node))
+				fails.parseAt(file, line, "Failed to parse %s: %s\n", kind, msg)
+				continue
+			}
+			if _, ok := injector.MetadataString(w.ObjectMeta(), "name"); !ok {
+				fails.noteFor(logFields{File: file, Kind: kind}, "%s in file %s has no valid metadata.name: using the filename instead\n", kind, file)
+			}
+			m.Workloads = append(m.Workloads, SourceWorkload{Kind: kind, Workload: w, Node: &node, File: file, Document: doc})
+			debugf("Valid %s found in file %s\n", kind, file)
+		}
+	}
+}
+
+// invalidBase64Keys returns the sorted keys whose values cannot be decoded as standard base64
+func invalidBase64Keys(data map[string]string) []string {
+	var invalid []string
+	for key, value := range data {
+		if _, err := base64.StdEncoding.DecodeString(value); err != nil {
+			invalid = append(invalid, key)
+		}
+	}
+	sort.Strings(invalid)
+	return invalid
+}
+
+// emptyDecodedKeys returns the sorted keys of the data values that are valid base64 but decode to no bytes
+func emptyDecodedKeys(data map[string]string) []string {
+	var empty []string
+	for key, value := range data {
+		if decoded, err := base64.StdEncoding.DecodeString(value); err == nil && len(decoded) == 0 {
+			empty = append(empty, key)
+		}
+	}
+	sort.Strings(empty)
+	return empty
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"env-deployment-k8s/pkg/injector"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name           string
+		data           string
+		wantKind       string
+		wantAPIVersion string
+		wantErr        error
+	}{
+		{
+			name:           "secret",
+			data:           "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app-secret\n",
+			wantKind:       "Secret",
+			wantAPIVersion: "v1",
+		},
+		{
+			name:           "deployment",
+			data:           "apiVersion: apps/v1\nkind: Deployment\n",
+			wantKind:       "Deployment",
+			wantAPIVersion: "apps/v1",
+		},
+		{
+			name: "empty document",
+			data: "",
+		},
+		{
+			name:    "missing kind",
+			data:    "apiVersion: v1\n",
+			wantErr: errMissingType,
+		},
+		{
+			name:    "kind is not a string",
+			data:    "apiVersion: v1\nkind: 5\n",
+			wantErr: errMissingType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, apiVersion, err := classify([]byte(tt.data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("classify() error = %v, want %v", err, tt.wantErr)
+			}
+			if kind != tt.wantKind || apiVersion != tt.wantAPIVersion {
+				t.Errorf("classify() = (%q, %q), want (%q, %q)", kind, apiVersion, tt.wantKind, tt.wantAPIVersion)
+			}
+		})
+	}
+}
+
+func TestClassifyInvalidYAML(t *testing.T) {
+	_, _, err := classify([]byte("kind: [\n"))
+	if err == nil {
+		t.Fatal("classify() expected an error for invalid YAML")
+	}
+}
+
+func TestMetadataString(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]interface{}
+		want     string
+		wantOk   bool
+	}{
+		{"string", map[string]interface{}{"name": "app-secret"}, "app-secret", true},
+		{"missing", map[string]interface{}{}, "", false},
+		{"nil metadata", nil, "", false},
+		{"number", map[string]interface{}{"name": 42}, "", false},
+		{"empty", map[string]interface{}{"name": ""}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := injector.MetadataString(tt.metadata, "name")
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("injector.MetadataString() = (%q, %v), want (%q, %v)", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestSecretKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret Secret
+		want   []string
+	}{
+		{
+			"declared order",
+			Secret{
+				Data:       map[string]string{"zeta": "", "alpha": ""},
+				StringData: map[string]string{"mid": "", "alpha": ""},
+				Keys:       []string{"zeta", "alpha", "mid", "alpha"},
+			},
+			[]string{"zeta", "alpha", "mid"},
+		},
+		{
+			"sorted without declared order",
+			Secret{
+				Data:       map[string]string{"zeta": "", "alpha": ""},
+				StringData: map[string]string{"mid": ""},
+			},
+			[]string{"alpha", "mid", "zeta"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := injector.SecretKeys(&tt.secret)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("injector.SecretKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFileAPIVersion(t *testing.T) {
+	data := "apiVersion: extensions/v1beta1\nkind: Deployment\nmetadata:\n  name: legacy\n---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: current\n"
+
+	m := &Manifests{}
+	m.parseFile("deployments.yaml", []byte(data), &options{}, newFailures(false))
+	if len(m.Workloads) != 1 {
+		t.Fatalf("parseFile() found %d workloads, want 1", len(m.Workloads))
+	}
+	if name, _ := injector.MetadataString(m.Workloads[0].Workload.ObjectMeta(), "name"); name != "current" {
+		t.Errorf("parseFile() kept %s, want current", name)
+	}
+}
+
+func TestEmptyDecodedKeys(t *testing.T) {
+	data := map[string]string{
+		"token":     "c2VjcmV0",
+		"empty":     "",
+		"blank":     "  ",
+		"notbase64": "plain text",
+	}
+	want := []string{"empty"}
+	if got := emptyDecodedKeys(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("emptyDecodedKeys() = %v, want %v", got, want)
+	}
+}
+
+func TestParseFileWindowsLineEndings(t *testing.T) {
+	data := "\xef\xbb\xbfapiVersion: v1\r\nkind: Secret\r\nmetadata:\r\n  name: app-secret\r\nstringData:\r\n  token: abc\r\n"
+
+	m := &Manifests{}
+	m.parseFile("secret.yaml", []byte(data), &options{}, newFailures(false))
+	if len(m.Secrets) != 1 {
+		t.Fatalf("parseFile() found %d Secrets, want 1", len(m.Secrets))
+	}
+	if got := m.Secrets[0].StringData["token"]; got != "abc" {
+		t.Errorf("token = %q, want %q", got, "abc")
+	}
+}
+
+func TestParseInputFileMaxFileSize(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret.yaml")
+	data := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app-secret\nstringData:\n  token: abc\n"
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fails := newFailures(false)
+	m := parseInputFile(file, &options{maxFileSize: 16}, fails)
+	if len(m.Secrets) != 0 || len(fails.skipped) != 1 {
+		t.Errorf("parseInputFile() kept %d Secrets and skipped %d files, want the file skipped", len(m.Secrets), len(fails.skipped))
+	}
+
+	m = parseInputFile(file, &options{maxFileSize: int64(len(data))}, newFailures(false))
+	if len(m.Secrets) != 1 {
+		t.Errorf("parseInputFile() found %d Secrets, want 1", len(m.Secrets))
+	}
+}
+
+func TestParseInputFileGenerated(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "api_updated.yaml.gz")
+	data, err := compress([]byte(generatedHeader + "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := parseInputFile(file, &options{}, newFailures(false)); len(m.Workloads) != 0 {
+		t.Errorf("parseInputFile() found %d workloads in a generated file, want none", len(m.Workloads))
+	}
+}
+
+func TestParseFilesTimeout(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret.yaml")
+	if err := os.WriteFile(file, []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: app-secret\nstringData:\n  token: abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fails := newFailures(false)
+	m := parseFiles(ctx, []string{file, file}, &options{workers: 1}, fails)
+	if len(m.Secrets) != 0 || len(fails.skipped) != 2 || !fails.timedOut {
+		t.Errorf("parseFiles() kept %d Secrets and skipped %+v, want both files skipped by the timeout", len(m.Secrets), fails.skipped)
+	}
+
+	fails = newFailures(false)
+	m = parseFiles(context.Background(), []string{file, file}, &options{workers: 1}, fails)
+	if len(m.Secrets) != 2 || fails.timedOut {
+		t.Errorf("parseFiles() found %d Secrets, want 2", len(m.Secrets))
+	}
+}
+
+func TestParseFileEmpty(t *testing.T) {
+	for _, data := range []string{"", "  \n\t\n", "\xef\xbb\xbf\r\n"} {
+		fails := newFailures(false)
+		m := &Manifests{}
+		m.parseFile("empty.yaml", []byte(data), &options{}, fails)
+		if len(fails.errors) != 0 || len(fails.skipped) != 1 || fails.skipped[0].Reason != "empty file" {
+			t.Errorf("parseFile(%q) errors = %v, skipped = %+v, want it skipped as an empty file", data, fails.errors, fails.skipped)
+		}
+	}
+}
+
+func TestParseFileErrorLine(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		opts options
+		want string
+	}{
+		{"syntax", "apiVersion: v1\nkind: ConfigMap\ndata: [\n", options{}, "bad.yaml:3: "},
+		{"type", "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app\n---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\nspec:\n  template:\n    spec:\n      containers: invalid\n", options{}, "bad.yaml:13: Failed to parse Deployment: cannot unmarshal"},
+		{"no name", "apiVersion: v1\nkind: Secret\nmetadata: {}\n---\n\napiVersion: v1\nkind: ConfigMap\n", options{}, "bad.yaml:1: Secret has no valid metadata.name"},
+		{"merged key", "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app\ndata: {<<: {bad: \"x!\"}}\n", options{validateSecrets: true}, "bad.yaml:1: Secret data key bad is not valid base64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fails := newFailures(false)
+			m := &Manifests{}
+			m.parseFile("bad.yaml", []byte(tt.data), &tt.opts, fails)
+			if len(fails.located) == 0 || !strings.HasPrefix(fails.located[0], tt.want) {
+				t.Errorf("parseFile() errors = %q, want one starting with %q", fails.located, tt.want)
+			}
+		})
+	}
+}
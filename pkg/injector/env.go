@@ -0,0 +1,441 @@
+package injector
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// BuildEnvVars creates the env vars for every key of the Secrets and ConfigMaps, along with the literal
+// env vars of opts.Env, sorted by name unless opts.Sort is none to keep them in declared order.
+// A key provided more than once is taken from the first source found, Secrets before ConfigMaps.
+// Env vars whose names collide are dropped with a warning, and invalid names are warned about,
+// while under opts.Strict either makes it fail.
+func BuildEnvVars(secrets []Secret, configMaps []ConfigMap, opts Options) ([]EnvVar, error) {
+	var envVars []EnvVar
+	keyOwners := make(map[string]string)
+	nameOwners := make(map[string]string)
+
+	// Distinct keys such as api_key and API_KEY can end up with the same env var name once the case
+	// is changed, which Kubernetes rejects, so only the first one is kept. A name that isn't an identifier,
+	// such as one with a dash or a non-ASCII letter, is kept with a warning.
+	var strictErr error
+	claimName := func(name, owner string) bool {
+		if !ValidEnvVarName(name) {
+			if opts.Strict {
+				if strictErr == nil {
+					strictErr = fmt.Errorf("env var %s from %s is not a valid name: it must match [A-Za-z_][A-Za-z0-9_]*", name, owner)
+				}
+				return false
+			}
+			opts.warnf("env var %s from %s is not a valid name: it must match [A-Za-z_][A-Za-z0-9_]*, Sanitize would name it %s\n", name, owner, SanitizeEnvVarName(name))
+		}
+		if other, ok := nameOwners[name]; ok {
+			if opts.Strict {
+				if strictErr == nil {
+					strictErr = fmt.Errorf("env var %s from %s collides with %s under Case %q", name, owner, other, opts.Case)
+				}
+				return false
+			}
+			opts.warnf("env var %s from %s collides with %s under Case %q: ignoring it\n", name, owner, other, opts.Case)
+			return false
+		}
+		nameOwners[name] = owner
+		return true
+	}
+
+	// -sanitize replaces the characters a name can't have, telling what became of the key
+	envVarName := func(key string) string {
+		name := EnvVarName(key, opts.Case, opts.Prefix)
+		if !opts.Sanitize || ValidEnvVarName(name) {
+			return name
+		}
+		sanitized := SanitizeEnvVarName(name)
+		opts.printf("Key %s would be env var %s, which is not a valid name: using %s\n", key, name, sanitized)
+		return sanitized
+	}
+
+	for i := range secrets {
+		secret := &secrets[i]
+		secretName, ok := MetadataString(secret.Metadata, "name")
+		if !ok {
+			opts.printf("Skipping a Secret without a valid metadata.name\n")
+			continue
+		}
+		if !EnvSecret(secret) {
+			opts.warnf("Secret %s has type %s, whose keys aren't env vars: skipping it\n", secretName, secret.Type)
+			continue
+		}
+		for _, key := range sortKeys(SecretKeys(secret), opts.SortKeys) {
+			if !keySelected(key, opts) {
+				continue
+			}
+			if owner, ok := keyOwners[key]; ok {
+				opts.warnf("Key %s in Secret %s is already provided by %s: ignoring\n", key, secretName, owner)
+				continue
+			}
+			keyOwners[key] = "Secret " + secretName
+
+			name := envVarName(key)
+			if !claimName(name, fmt.Sprintf("key %s in Secret %s", key, secretName)) {
+				continue
+			}
+			envVars = append(envVars, EnvVar{
+				Name: name,
+				ValueFrom: &ValueFromRef{
+					SecretKeyRef: &SecretKeyRef{
+						Name: secretRefName(secretName, opts),
+						Key:  key,
+					},
+				},
+			})
+		}
+	}
+
+	for _, configMap := range configMaps {
+		configMapName, ok := MetadataString(configMap.Metadata, "name")
+		if !ok {
+			opts.printf("Skipping a ConfigMap without a valid metadata.name\n")
+			continue
+		}
+		for _, key := range sortKeys(ConfigMapKeys(&configMap), opts.SortKeys) {
+			if !keySelected(key, opts) {
+				continue
+			}
+			if owner, ok := keyOwners[key]; ok {
+				opts.warnf("Key %s in ConfigMap %s is already provided by %s: ignoring\n", key, configMapName, owner)
+				continue
+			}
+			keyOwners[key] = "ConfigMap " + configMapName
+
+			name := envVarName(key)
+			if !claimName(name, fmt.Sprintf("key %s in ConfigMap %s", key, configMapName)) {
+				continue
+			}
+			envVars = append(envVars, EnvVar{
+				Name: name,
+				ValueFrom: &ValueFromRef{
+					ConfigMapKeyRef: &ConfigMapKeyRef{
+						Name: configMapName,
+						Key:  key,
+					},
+				},
+			})
+		}
+	}
+
+	// The literal env vars go with the ones from the Secrets and ConfigMaps, which win a clash
+	for _, env := range opts.Env {
+		if owner, ok := nameOwners[env.Name]; ok {
+			opts.printf("Env var %s from Env is already provided by %s: ignoring\n", env.Name, owner)
+			continue
+		}
+		nameOwners[env.Name] = "Env"
+		envVars = append(envVars, env)
+	}
+
+	if strictErr != nil {
+		return nil, strictErr
+	}
+	if opts.Sort != "none" {
+		SortEnv(envVars)
+	}
+	return envVars, nil
+}
+
+// keySelected reports whether a key passes opts.IncludeKeys and opts.ExcludeKeys
+func keySelected(key string, opts Options) bool {
+	if len(opts.IncludeKeys) > 0 && !opts.IncludeKeys[key] {
+		return false
+	}
+	return !opts.ExcludeKeys[key]
+}
+
+// BuildEnvFromSources creates one envFrom entry per Secret and ConfigMap. The -env-prefix,
+// joined with _, becomes the envFrom prefix; keys are otherwise used as they are.
+func BuildEnvFromSources(secrets []Secret, configMaps []ConfigMap, opts Options) []EnvFromSource {
+	prefix := ""
+	if opts.Prefix != "" {
+		prefix = opts.Prefix + "_"
+	}
+
+	var sources []EnvFromSource
+	referenced := make(map[string]bool)
+	for _, secret := range secrets {
+		if !EnvSecret(&secret) {
+			continue
+		}
+		if name, ok := MetadataString(secret.Metadata, "name"); ok && !referenced[secretRefName(name, opts)] {
+			// Every Secret maps to the same reference under -secret-name, which is only needed once
+			referenced[secretRefName(name, opts)] = true
+			sources = append(sources, EnvFromSource{Prefix: prefix, SecretRef: &EnvFromRef{Name: secretRefName(name, opts)}})
+		}
+	}
+	for _, configMap := range configMaps {
+		if name, ok := MetadataString(configMap.Metadata, "name"); ok {
+			sources = append(sources, EnvFromSource{Prefix: prefix, ConfigMapRef: &EnvFromRef{Name: name}})
+		}
+	}
+	return sources
+}
+
+// secretRefName returns the Secret name written into references to a parsed Secret: its own
+// name between opts.SecretPrefix and opts.SecretSuffix, or opts.SecretName for the Secret that
+// really exists in the cluster
+func secretRefName(name string, opts Options) string {
+	if opts.SecretName != "" {
+		return opts.SecretName
+	}
+	return opts.SecretPrefix + name + opts.SecretSuffix
+}
+
+// EnvVarName converts a Secret or ConfigMap key to an env var name in the given case mode,
+// prepending the prefix and an underscore when a prefix is set. ascii-upper upper-cases only
+// the ASCII letters, leaving letters such as ß as they are.
+func EnvVarName(key, caseMode, prefix string) string {
+	var name string
+	switch caseMode {
+	case "ascii-upper":
+		name = strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' {
+				return r - 'a' + 'A'
+			}
+			return r
+		}, key)
+	case "lower":
+		name = strings.ToLower(key)
+	case "preserve":
+		name = key
+	default:
+		name = strings.ToUpper(key)
+	}
+
+	if prefix != "" {
+		name = prefix + "_" + name
+	}
+	return name
+}
+
+// ValidEnvVarName reports whether a name is an identifier, [A-Za-z_][A-Za-z0-9_]*, which every
+// shell and language runtime can read from the environment
+func ValidEnvVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// SanitizeEnvVarName turns a name into a valid one by replacing every character other than an ASCII
+// letter, digit or underscore with an underscore, and putting an underscore before a leading digit
+func SanitizeEnvVarName(name string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+	if sanitized == "" || sanitized[0] >= '0' && sanitized[0] <= '9' {
+		sanitized = "_" + sanitized
+	}
+	return sanitized
+}
+
+// sortKeys returns the keys of a Secret or ConfigMap in the opts.SortKeys order, leaving them in
+// declared order when it is empty
+func sortKeys(keys []string, order string) []string {
+	switch order {
+	case "asc":
+		sort.Strings(keys)
+	case "desc":
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	}
+	return keys
+}
+
+// SortEnv sorts the env vars by name
+func SortEnv(envVars []EnvVar) {
+	sort.Slice(envVars, func(i, j int) bool {
+		return envVars[i].Name < envVars[j].Name
+	})
+}
+
+// InjectedContainer records how many env vars were injected into a container
+type InjectedContainer struct {
+	Name  string
+	Count int
+}
+
+// ContainerSelector picks the containers to inject into: the ones in Names whose image matches
+// the Image glob, such as registry.internal/*. With a Marker only the containers that declare
+// an env var of that name are picked, and the injected env vars take the marker's place.
+// An empty selector picks every container.
+type ContainerSelector struct {
+	Names  map[string]bool
+	Image  string
+	Marker string
+}
+
+// Selects reports whether the container should be injected into
+func (sel ContainerSelector) Selects(container Container) bool {
+	if len(sel.Names) > 0 && !sel.Names[container.Name] {
+		return false
+	}
+	if sel.Marker != "" && markerIndex(container.Env, sel.Marker) < 0 {
+		return false
+	}
+	if sel.Image != "" {
+		matched, _ := path.Match(sel.Image, container.Image)
+		return matched
+	}
+	return true
+}
+
+// InjectEnv assigns the env vars to the workload's init containers and containers, replacing their
+// existing env vars or merging into them. Only the containers the selector picks are touched.
+func InjectEnv(w Workload, envVars []EnvVar, merge bool, selector ContainerSelector) []InjectedContainer {
+	podSpec := w.PodSpec()
+	injected := setContainerEnv(podSpec.InitContainers, envVars, merge, selector)
+	return append(injected, setContainerEnv(podSpec.Containers, envVars, merge, selector)...)
+}
+
+// setContainerEnv replaces the env vars of the selected containers, or merges them into the existing ones
+func setContainerEnv(containers []Container, envVars []EnvVar, merge bool, selector ContainerSelector) []InjectedContainer {
+	var injected []InjectedContainer
+	for i := range containers {
+		if !selector.Selects(containers[i]) {
+			continue
+		}
+		switch {
+		case selector.Marker != "":
+			containers[i].Env = replaceMarker(containers[i].Env, selector.Marker, envVars)
+		case merge:
+			containers[i].Env = mergeEnvVars(containers[i].Env, envVars)
+		default:
+			containers[i].Env = envVars
+		}
+		injected = append(injected, InjectedContainer{Name: containers[i].Name, Count: len(envVars)})
+	}
+	return injected
+}
+
+// mergeEnvVars keeps the existing env vars in place, overwriting the ones that share a name
+// with an injected env var and appending the injected env vars that are not present yet. An overwritten
+// env var is replaced whole, so a literal value never ends up next to a valueFrom.
+func mergeEnvVars(existing, injected []EnvVar) []EnvVar {
+	injectedByName := make(map[string]EnvVar, len(injected))
+	for _, env := range injected {
+		injectedByName[env.Name] = env
+	}
+
+	merged := make([]EnvVar, 0, len(existing)+len(injected))
+	seen := make(map[string]bool, len(existing))
+	for _, env := range existing {
+		if replacement, ok := injectedByName[env.Name]; ok {
+			env = replacement
+		}
+		merged = append(merged, env)
+		seen[env.Name] = true
+	}
+
+	for _, env := range injected {
+		if !seen[env.Name] {
+			merged = append(merged, env)
+		}
+	}
+
+	return merged
+}
+
+// markerIndex returns the position of the marker env var, or -1 when the container doesn't declare it
+func markerIndex(env []EnvVar, marker string) int {
+	for i := range env {
+		if env[i].Name == marker {
+			return i
+		}
+	}
+	return -1
+}
+
+// replaceMarker puts the injected env vars where the marker env var was, keeping the container's
+// other env vars around them unless an injected env var has the same name
+func replaceMarker(existing []EnvVar, marker string, injected []EnvVar) []EnvVar {
+	injectedNames := make(map[string]bool, len(injected))
+	for _, env := range injected {
+		injectedNames[env.Name] = true
+	}
+
+	var replaced []EnvVar
+	for _, env := range existing {
+		switch {
+		case env.Name == marker:
+			replaced = append(replaced, injected...)
+		case !injectedNames[env.Name]:
+			replaced = append(replaced, env)
+		}
+	}
+	return replaced
+}
+
+// InjectEnvFrom assigns the envFrom entries to the workload's init containers and containers the selector
+// picks, replacing their existing envFrom entries or adding the ones that are missing. The selector's
+// marker env var is removed, since the envFrom entries replace it.
+func InjectEnvFrom(w Workload, sources []EnvFromSource, merge bool, selector ContainerSelector) []InjectedContainer {
+	var injected []InjectedContainer
+	podSpec := w.PodSpec()
+	for _, containers := range [][]Container{podSpec.InitContainers, podSpec.Containers} {
+		for i := range containers {
+			if !selector.Selects(containers[i]) {
+				continue
+			}
+			if selector.Marker != "" {
+				containers[i].Env = replaceMarker(containers[i].Env, selector.Marker, nil)
+			}
+			if merge {
+				containers[i].EnvFrom = mergeEnvFrom(containers[i].EnvFrom, sources)
+			} else {
+				containers[i].EnvFrom = sources
+			}
+			injected = append(injected, InjectedContainer{Name: containers[i].Name, Count: len(sources)})
+		}
+	}
+	return injected
+}
+
+// mergeEnvFrom appends the envFrom entries whose Secret or ConfigMap isn't referenced yet
+func mergeEnvFrom(existing, injected []EnvFromSource) []EnvFromSource {
+	merged := append([]EnvFromSource{}, existing...)
+	for _, source := range injected {
+		found := false
+		for _, current := range existing {
+			if envFromKey(current) == envFromKey(source) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, source)
+		}
+	}
+	return merged
+}
+
+// envFromKey identifies the Secret or ConfigMap an envFrom entry references
+func envFromKey(source EnvFromSource) string {
+	switch {
+	case source.SecretRef != nil:
+		return "Secret/" + source.SecretRef.Name
+	case source.ConfigMapRef != nil:
+		return "ConfigMap/" + source.ConfigMapRef.Name
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,518 @@
+package injector
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func secretEnv(name, secretName, key string) EnvVar {
+	return EnvVar{
+		Name:      name,
+		ValueFrom: &ValueFromRef{SecretKeyRef: &SecretKeyRef{Name: secretName, Key: key}},
+	}
+}
+
+func TestEnvVarName(t *testing.T) {
+	tests := []struct {
+		key      string
+		caseMode string
+		prefix   string
+		want     string
+	}{
+		{"db_password", "upper", "", "DB_PASSWORD"},
+		{"Db_Password", "lower", "", "db_password"},
+		{"Db_Password", "preserve", "", "Db_Password"},
+		{"db_password", "upper", "PAYMENTS", "PAYMENTS_DB_PASSWORD"},
+		{"straße_über", "ascii-upper", "", "STRAßE_üBER"},
+	}
+
+	for _, tt := range tests {
+		if got := EnvVarName(tt.key, tt.caseMode, tt.prefix); got != tt.want {
+			t.Errorf("EnvVarName(%q, %q, %q) = %q, want %q", tt.key, tt.caseMode, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestValidEnvVarName(t *testing.T) {
+	for name, want := range map[string]bool{"DB_PASSWORD": true, "_private": true, "v2": true, "": false, "2FA": false, "api-key": false, "STRAßE": false} {
+		if got := ValidEnvVarName(name); got != want {
+			t.Errorf("ValidEnvVarName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestBuildEnvVarsInvalidName(t *testing.T) {
+	secrets := []Secret{{Metadata: map[string]interface{}{"name": "app"}, Keys: []string{"api-key"}, Data: map[string]string{"api-key": "a2V5"}}}
+
+	var warnings []string
+	envVars, err := BuildEnvVars(secrets, nil, Options{Warnf: func(format string, args ...interface{}) { warnings = append(warnings, format) }})
+	if err != nil || len(envVars) != 1 || len(warnings) != 1 {
+		t.Errorf("BuildEnvVars() = %+v, %v with %d warnings, want API-KEY kept with a warning", envVars, err, len(warnings))
+	}
+
+	if _, err := BuildEnvVars(secrets, nil, Options{Strict: true}); err == nil {
+		t.Error("BuildEnvVars() expected an error for an invalid name under Strict")
+	}
+}
+
+func TestBuildEnvVarsKeyConflict(t *testing.T) {
+	secrets := []Secret{
+		{Metadata: map[string]interface{}{"name": "db-secret"}, Data: map[string]string{"password": "YQ=="}},
+		{Metadata: map[string]interface{}{"name": "api-secret"}, Data: map[string]string{"password": "Yg=="}},
+	}
+
+	var warnings, messages []string
+	envVars, err := BuildEnvVars(secrets, nil, Options{
+		Warnf:  func(format string, args ...interface{}) { warnings = append(warnings, fmt.Sprintf(format, args...)) },
+		Printf: func(format string, args ...interface{}) { messages = append(messages, fmt.Sprintf(format, args...)) },
+	})
+	if err != nil || len(envVars) != 1 || envVars[0].ValueFrom.SecretKeyRef.Name != "db-secret" {
+		t.Fatalf("BuildEnvVars() = %+v, %v, want password from db-secret only", envVars, err)
+	}
+	if len(warnings) != 1 || len(messages) != 0 {
+		t.Errorf("warnings = %q, messages = %q, want the conflict as a single warning", warnings, messages)
+	}
+}
+
+func TestBuildEnvVarsMessagesNameOptions(t *testing.T) {
+	secrets := []Secret{{
+		Metadata: map[string]interface{}{"name": "app"},
+		Data:     map[string]string{"api_key": "YQ==", "API_KEY": "Yg==", "db.url": "dXJs"},
+		Keys:     []string{"api_key", "API_KEY", "db.url"},
+	}}
+
+	var messages []string
+	record := func(format string, args ...interface{}) { messages = append(messages, fmt.Sprintf(format, args...)) }
+	_, err := BuildEnvVars(secrets, nil, Options{Case: "upper", Env: []EnvVar{{Name: "API_KEY", Value: "x"}}, Warnf: record, Printf: record})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = BuildEnvVars(secrets, nil, Options{Case: "upper", Strict: true})
+	if err != nil {
+		messages = append(messages, err.Error())
+	}
+
+	if len(messages) != 4 {
+		t.Fatalf("messages = %q, want a collision, an invalid name, an Env clash and an error", messages)
+	}
+	for _, message := range messages {
+		if strings.Contains(message, " -") {
+			t.Errorf("message %q names a command-line flag", message)
+		}
+	}
+}
+
+func TestSanitizeEnvVarName(t *testing.T) {
+	for name, want := range map[string]string{"DB.URL": "DB_URL", "API-KEY": "API_KEY", "2FA": "_2FA", "STRAßE": "STRA_E", "OK": "OK"} {
+		if got := SanitizeEnvVarName(name); got != want {
+			t.Errorf("SanitizeEnvVarName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestBuildEnvVarsSanitize(t *testing.T) {
+	secrets := []Secret{{Metadata: map[string]interface{}{"name": "app"}, Keys: []string{"db.url"}, Data: map[string]string{"db.url": "dXJs"}}}
+
+	envVars, err := BuildEnvVars(secrets, nil, Options{Sanitize: true, Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envVars) != 1 || envVars[0].Name != "DB_URL" || envVars[0].ValueFrom.SecretKeyRef.Key != "db.url" {
+		t.Errorf("BuildEnvVars() = %+v, want DB_URL referencing key db.url", envVars)
+	}
+}
+
+func TestSortEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"nil", nil, nil},
+		{"sorted", []string{"A", "B"}, []string{"A", "B"}},
+		{"unsorted", []string{"DB_URL", "API_KEY", "B"}, []string{"API_KEY", "B", "DB_URL"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var envVars []EnvVar
+			for _, name := range tt.in {
+				envVars = append(envVars, EnvVar{Name: name})
+			}
+			SortEnv(envVars)
+
+			var got []string
+			for _, env := range envVars {
+				got = append(got, env.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SortEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortKeys(t *testing.T) {
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{"", []string{"zeta", "alpha", "mid"}},
+		{"asc", []string{"alpha", "mid", "zeta"}},
+		{"desc", []string{"zeta", "mid", "alpha"}},
+	}
+
+	for _, tt := range tests {
+		if got := sortKeys([]string{"zeta", "alpha", "mid"}, tt.order); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sortKeys(%q) = %v, want %v", tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestBuildEnvVars(t *testing.T) {
+	secrets := []Secret{{
+		Metadata:   map[string]interface{}{"name": "app-secret"},
+		Data:       map[string]string{"db_password": "cGFzcw==", "api_key": "a2V5"},
+		StringData: map[string]string{"api_key": "ignored", "token": "plain"},
+	}}
+
+	got, err := BuildEnvVars(secrets, nil, Options{Case: "upper"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EnvVar{
+		secretEnv("API_KEY", "app-secret", "api_key"),
+		secretEnv("DB_PASSWORD", "app-secret", "db_password"),
+		secretEnv("TOKEN", "app-secret", "token"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildEnvVars() = %+v, want %+v", got, want)
+	}
+}
+
+func TestBuildEnvVarsKeyFilters(t *testing.T) {
+	secrets := []Secret{{
+		Metadata: map[string]interface{}{"name": "app-secret"},
+		Data:     map[string]string{"db_url": "", "api_key": "", "smtp_password": ""},
+	}}
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"all keys", Options{}, []string{"API_KEY", "DB_URL", "SMTP_PASSWORD"}},
+		{"include", Options{IncludeKeys: map[string]bool{"db_url": true, "api_key": true, "missing": true}}, []string{"API_KEY", "DB_URL"}},
+		{"exclude", Options{ExcludeKeys: map[string]bool{"smtp_password": true}}, []string{"API_KEY", "DB_URL"}},
+		{"both", Options{IncludeKeys: map[string]bool{"db_url": true, "api_key": true}, ExcludeKeys: map[string]bool{"api_key": true}}, []string{"DB_URL"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Case = "upper"
+			var got []string
+			envVars, err := BuildEnvVars(secrets, nil, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, env := range envVars {
+				got = append(got, env.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("BuildEnvVars() names = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildEnvVarsCaseCollision(t *testing.T) {
+	secrets := []Secret{{
+		Metadata: map[string]interface{}{"name": "app-secret"},
+		Data:     map[string]string{"api_key": "a2V5", "API_KEY": "S0VZ"},
+		Keys:     []string{"api_key", "API_KEY"},
+	}}
+
+	got, err := BuildEnvVars(secrets, nil, Options{Case: "upper"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EnvVar{secretEnv("API_KEY", "app-secret", "api_key")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildEnvVars() = %+v, want %+v", got, want)
+	}
+}
+
+func TestInjectEnv(t *testing.T) {
+	injected := []EnvVar{secretEnv("API_KEY", "app-secret", "api_key")}
+
+	tests := []struct {
+		name     string
+		existing []EnvVar
+		merge    bool
+		want     []EnvVar
+	}{
+		{
+			name:     "clears existing env",
+			existing: []EnvVar{{Name: "LOG_LEVEL"}},
+			want:     injected,
+		},
+		{
+			name:     "no existing env",
+			existing: nil,
+			want:     injected,
+		},
+		{
+			name:     "merge keeps unrelated env",
+			existing: []EnvVar{{Name: "LOG_LEVEL"}},
+			merge:    true,
+			want:     []EnvVar{{Name: "LOG_LEVEL"}, injected[0]},
+		},
+		{
+			name:     "merge overwrites clashing env",
+			existing: []EnvVar{{Name: "API_KEY"}, {Name: "LOG_LEVEL"}},
+			merge:    true,
+			want:     []EnvVar{injected[0], {Name: "LOG_LEVEL"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dep := Deployment{}
+			dep.Spec.Template.Spec.InitContainers = []Container{{Name: "init", Env: tt.existing}}
+			dep.Spec.Template.Spec.Containers = []Container{{Name: "app", Env: tt.existing}}
+
+			InjectEnv(&dep, injected, tt.merge, ContainerSelector{})
+
+			for _, container := range append(dep.Spec.Template.Spec.InitContainers, dep.Spec.Template.Spec.Containers...) {
+				if !reflect.DeepEqual(container.Env, tt.want) {
+					t.Errorf("container %s env = %+v, want %+v", container.Name, container.Env, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestInjectEnvContainers(t *testing.T) {
+	injected := []EnvVar{secretEnv("API_KEY", "app-secret", "api_key")}
+	existing := []EnvVar{{Name: "PROXY_MODE"}}
+
+	dep := Deployment{}
+	dep.Spec.Template.Spec.Containers = []Container{{Name: "app"}, {Name: "istio-proxy", Env: existing}}
+
+	got := InjectEnv(&dep, injected, false, ContainerSelector{Names: map[string]bool{"app": true}})
+
+	if len(got) != 1 || got[0].Name != "app" {
+		t.Errorf("InjectEnv() = %+v, want only app", got)
+	}
+	containers := dep.Spec.Template.Spec.Containers
+	if !reflect.DeepEqual(containers[0].Env, injected) {
+		t.Errorf("app env = %+v, want %+v", containers[0].Env, injected)
+	}
+	if !reflect.DeepEqual(containers[1].Env, existing) {
+		t.Errorf("istio-proxy env = %+v, want it untouched", containers[1].Env)
+	}
+}
+
+func TestInjectEnvMarker(t *testing.T) {
+	injected := []EnvVar{secretEnv("API_KEY", "app-secret", "api_key"), secretEnv("LOG_LEVEL", "app-secret", "log_level")}
+
+	dep := Deployment{}
+	dep.Spec.Template.Spec.Containers = []Container{
+		{Name: "app", Env: []EnvVar{{Name: "PORT", Value: "8080"}, {Name: "__INJECT_SECRETS__"}, {Name: "LOG_LEVEL", Value: "debug"}}},
+		{Name: "sidecar", Env: []EnvVar{{Name: "PROXY_MODE"}}},
+	}
+
+	got := InjectEnv(&dep, injected, false, ContainerSelector{Marker: "__INJECT_SECRETS__"})
+
+	if len(got) != 1 || got[0].Name != "app" {
+		t.Errorf("InjectEnv() = %+v, want only app", got)
+	}
+	containers := dep.Spec.Template.Spec.Containers
+	want := []EnvVar{{Name: "PORT", Value: "8080"}, injected[0], injected[1]}
+	if !reflect.DeepEqual(containers[0].Env, want) {
+		t.Errorf("app env = %+v, want %+v", containers[0].Env, want)
+	}
+	if !reflect.DeepEqual(containers[1].Env, []EnvVar{{Name: "PROXY_MODE"}}) {
+		t.Errorf("sidecar env = %+v, want it untouched", containers[1].Env)
+	}
+}
+
+func TestContainerSelector(t *testing.T) {
+	tests := []struct {
+		name      string
+		selector  ContainerSelector
+		container Container
+		want      bool
+	}{
+		{"empty", ContainerSelector{}, Container{Name: "app", Image: "nginx"}, true},
+		{"name", ContainerSelector{Names: map[string]bool{"app": true}}, Container{Name: "sidecar"}, false},
+		{"image match", ContainerSelector{Image: "registry.internal/*"}, Container{Name: "app", Image: "registry.internal/app:1.2"}, true},
+		{"image mismatch", ContainerSelector{Image: "registry.internal/*"}, Container{Name: "proxy", Image: "docker.io/istio/proxyv2:1.20"}, false},
+		{"name and image", ContainerSelector{Names: map[string]bool{"app": true}, Image: "registry.internal/*"}, Container{Name: "app", Image: "nginx"}, false},
+		{"marker", ContainerSelector{Marker: "__INJECT_SECRETS__"}, Container{Name: "app", Env: []EnvVar{{Name: "__INJECT_SECRETS__"}}}, true},
+		{"no marker", ContainerSelector{Marker: "__INJECT_SECRETS__"}, Container{Name: "app", Env: []EnvVar{{Name: "LOG_LEVEL"}}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.selector.Selects(tt.container); got != tt.want {
+				t.Errorf("Selects(%+v) = %v, want %v", tt.container, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildEnvVarsLiteral(t *testing.T) {
+	secrets := []Secret{{
+		Metadata: map[string]interface{}{"name": "app-secret"},
+		Data:     map[string]string{"db_url": "", "environment": ""},
+	}}
+	opts := Options{Case: "upper", Env: []EnvVar{{Name: "ENVIRONMENT", Value: "staging"}, {Name: "REGION", Value: "eu"}, {Name: "APP_MODE", Value: "web"}}}
+
+	got, err := BuildEnvVars(secrets, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EnvVar{
+		{Name: "APP_MODE", Value: "web"},
+		secretEnv("DB_URL", "app-secret", "db_url"),
+		secretEnv("ENVIRONMENT", "app-secret", "environment"),
+		{Name: "REGION", Value: "eu"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildEnvVars() = %+v, want %+v", got, want)
+	}
+}
+
+func TestMergeEnvVarsLiteral(t *testing.T) {
+	existing := []EnvVar{{Name: "LOG_LEVEL", Value: "debug"}, {Name: "API_KEY", Value: "hardcoded"}}
+	injected := []EnvVar{secretEnv("API_KEY", "app-secret", "api_key"), {Name: "REGION", Value: "eu"}}
+
+	got := mergeEnvVars(existing, injected)
+	want := []EnvVar{{Name: "LOG_LEVEL", Value: "debug"}, injected[0], injected[1]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeEnvVars() = %+v, want %+v", got, want)
+	}
+}
+
+func TestEnvVarValueFromRoundTrip(t *testing.T) {
+	input := `- name: POD_NAME
+  valueFrom:
+    fieldRef:
+      apiVersion: v1
+      fieldPath: metadata.name
+- name: MEMORY_LIMIT
+  valueFrom:
+    resourceFieldRef:
+      containerName: app
+      resource: limits.memory
+      divisor: 1Mi
+- name: LOG_LEVEL
+  valueFrom:
+    configMapKeyRef:
+      name: app-config
+      key: log_level
+`
+	var envVars []EnvVar
+	if err := yaml.Unmarshal([]byte(input), &envVars); err != nil {
+		t.Fatal(err)
+	}
+	if len(envVars) != 3 || envVars[0].ValueFrom.FieldRef == nil || envVars[1].ValueFrom.ResourceFieldRef == nil || envVars[2].ValueFrom.ConfigMapKeyRef == nil {
+		t.Fatalf("decoded env = %+v, want fieldRef, resourceFieldRef and configMapKeyRef", envVars)
+	}
+
+	out, err := yaml.Marshal(mergeEnvVars(envVars, []EnvVar{secretEnv("API_KEY", "app-secret", "api_key")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roundTrip []EnvVar
+	if err := yaml.Unmarshal(out, &roundTrip); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(roundTrip[:3], envVars) {
+		t.Errorf("round-tripped env = %+v, want %+v", roundTrip[:3], envVars)
+	}
+}
+
+func TestBuildEnvVarsSecretName(t *testing.T) {
+	secrets := []Secret{{
+		Metadata: map[string]interface{}{"name": "app-secret-keys"},
+		Data:     map[string]string{"api_key": ""},
+	}}
+
+	got, err := BuildEnvVars(secrets, nil, Options{Case: "upper", SecretName: "app-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EnvVar{secretEnv("API_KEY", "app-secret", "api_key")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildEnvVars() = %+v, want %+v", got, want)
+	}
+}
+
+func TestBuildEnvVarsSecretPrefix(t *testing.T) {
+	secrets := []Secret{{
+		Metadata: map[string]interface{}{"name": "app-secret"},
+		Data:     map[string]string{"api_key": ""},
+	}}
+
+	got, err := BuildEnvVars(secrets, nil, Options{Case: "upper", SecretPrefix: "pr-42-", SecretSuffix: "-copy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EnvVar{secretEnv("API_KEY", "pr-42-app-secret-copy", "api_key")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildEnvVars() = %+v, want %+v", got, want)
+	}
+}
+
+func TestBuildEnvVarsStrictCollision(t *testing.T) {
+	secrets := []Secret{{
+		Metadata: map[string]interface{}{"name": "app-secret"},
+		Data:     map[string]string{"api_key": "a2V5", "API_KEY": "S0VZ"},
+		Keys:     []string{"api_key", "API_KEY"},
+	}}
+
+	if _, err := BuildEnvVars(secrets, nil, Options{Strict: true}); err == nil {
+		t.Error("BuildEnvVars() error = nil, want the collision reported under Strict")
+	}
+}
+
+func TestInjectSecretEnv(t *testing.T) {
+	secret := &Secret{
+		Metadata: map[string]interface{}{"name": "app-secret"},
+		Data:     map[string]string{"api_key": "a2V5"},
+	}
+	dep := &Deployment{}
+	dep.Spec.Template.Spec.Containers = []Container{{Name: "app", Env: []EnvVar{{Name: "LOG_LEVEL", Value: "debug"}}}}
+
+	if err := InjectSecretEnv(dep, secret, Options{Merge: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := []EnvVar{{Name: "LOG_LEVEL", Value: "debug"}, secretEnv("API_KEY", "app-secret", "api_key")}
+	if got := dep.Spec.Template.Spec.Containers[0].Env; !reflect.DeepEqual(got, want) {
+		t.Errorf("InjectSecretEnv() env = %+v, want %+v", got, want)
+	}
+
+	if err := InjectSecretEnv(dep, &Secret{}, Options{}); err == nil {
+		t.Error("InjectSecretEnv() error = nil for a Secret without a name")
+	}
+}
+
+func TestAddImagePullSecret(t *testing.T) {
+	podSpec := &PodSpec{ImagePullSecrets: []LocalObjectReference{{Name: "registry"}}}
+	if AddImagePullSecret(podSpec, "registry") {
+		t.Error("AddImagePullSecret() added a Secret already referenced")
+	}
+	if !AddImagePullSecret(podSpec, "mirror") {
+		t.Error("AddImagePullSecret() = false for a new Secret")
+	}
+	want := []LocalObjectReference{{Name: "registry"}, {Name: "mirror"}}
+	if !reflect.DeepEqual(podSpec.ImagePullSecrets, want) {
+		t.Errorf("imagePullSecrets = %+v, want %+v", podSpec.ImagePullSecrets, want)
+	}
+}
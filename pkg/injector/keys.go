@@ -0,0 +1,87 @@
+package injector
+
+import "sort"
+
+// MetadataString returns a non-empty string field from an object's metadata. Templated
+// manifests can leave a field missing or render it as another type, which is reported as not ok.
+func MetadataString(metadata map[string]interface{}, key string) (string, bool) {
+	value, ok := metadata[key].(string)
+	if !ok || value == "" {
+		return "", false
+	}
+	return value, true
+}
+
+// SecretKeys returns the keys of both data and stringData, listing a key present in both only once
+// since data takes precedence over stringData. Keys come in declared order when it is known,
+// otherwise sorted.
+func SecretKeys(secret *Secret) []string {
+	present := func(key string) bool {
+		_, inData := secret.Data[key]
+		_, inStringData := secret.StringData[key]
+		return inData || inStringData
+	}
+	return orderedKeys(secret.Keys, present, secret.Data, secret.StringData)
+}
+
+// ConfigMapKeys returns the keys of the ConfigMap's data in declared order when it is known, otherwise sorted
+func ConfigMapKeys(configMap *ConfigMap) []string {
+	present := func(key string) bool {
+		_, ok := configMap.Data[key]
+		return ok
+	}
+	return orderedKeys(configMap.Keys, present, configMap.Data)
+}
+
+// orderedKeys returns the declared keys that are present, each once, or the sorted keys of the maps
+// when no order was recorded
+func orderedKeys(declared []string, present func(string) bool, maps ...map[string]string) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	if declared != nil {
+		for _, key := range declared {
+			if present(key) {
+				add(key)
+			}
+		}
+		return keys
+	}
+
+	for _, values := range maps {
+		for key := range values {
+			add(key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EnvSecret reports whether the Secret's keys are meant to become env vars. Only Opaque Secrets,
+// the default type, are: the other types hold credentials for Kubernetes itself.
+func EnvSecret(secret *Secret) bool {
+	return secret.Type == "" || secret.Type == "Opaque"
+}
+
+// PullSecret reports whether the Secret holds registry credentials that belong in imagePullSecrets
+func PullSecret(secret *Secret) bool {
+	return secret.Type == "kubernetes.io/dockerconfigjson" || secret.Type == "kubernetes.io/dockercfg"
+}
+
+// AddImagePullSecret references the Secret from the pod spec's imagePullSecrets, reporting false
+// when it already is
+func AddImagePullSecret(podSpec *PodSpec, name string) bool {
+	for _, ref := range podSpec.ImagePullSecrets {
+		if ref.Name == name {
+			return false
+		}
+	}
+	podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, LocalObjectReference{Name: name})
+	return true
+}
@@ -0,0 +1,72 @@
+package injector
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Options controls how env vars are built from Secrets and ConfigMaps. The zero value upper-cases
+// every key, sorts the env vars by name and discards the messages.
+type Options struct {
+	// Case of the env var names: upper, ascii-upper, lower or preserve. Empty means upper.
+	Case string
+	// Prefix is prepended to every env var name, joined with _
+	Prefix string
+	// IncludeKeys, when not empty, are the only keys turned into env vars
+	IncludeKeys map[string]bool
+	// ExcludeKeys are never turned into env vars
+	ExcludeKeys map[string]bool
+	// Sort orders the env vars by name, unless it is none to keep the declared order
+	Sort string
+	// SortKeys reads the keys of every Secret and ConfigMap in asc or desc order instead of the declared one
+	SortKeys string
+	// Env are literal env vars added next to the ones from the Secrets and ConfigMaps
+	Env []EnvVar
+	// SecretName, when set, is referenced instead of the name of the Secret the keys come from
+	SecretName string
+	// SecretPrefix and SecretSuffix are put around the names of the Secrets referenced, for copies
+	// of the Secrets renamed that way
+	SecretPrefix string
+	SecretSuffix string
+	// Sanitize replaces the characters env var names can't have, such as the dot in db.url, with _
+	Sanitize bool
+	// Strict turns env var name collisions and invalid names into an error
+	Strict bool
+
+	// Merge keeps the existing env vars of the containers, overwriting the ones with the same name
+	Merge bool
+	// Containers picks the containers to inject into, all of them when empty
+	Containers ContainerSelector
+
+	// Warnf receives the warnings and Printf the other messages, each ending in a newline.
+	// Both may be nil to discard them.
+	Warnf  func(format string, args ...interface{})
+	Printf func(format string, args ...interface{})
+}
+
+func (opts Options) warnf(format string, args ...interface{}) {
+	if opts.Warnf != nil {
+		opts.Warnf(format, args...)
+	}
+}
+
+func (opts Options) printf(format string, args ...interface{}) {
+	if opts.Printf != nil {
+		opts.Printf(format, args...)
+	}
+}
+
+// InjectSecretEnv injects an env var referencing the Secret for each of its keys into the containers
+// of a workload, such as a *Deployment
+func InjectSecretEnv(w Workload, secret *Secret, opts Options) error {
+	name, ok := MetadataString(secret.Metadata, "name")
+	if !ok {
+		return errors.New("secret has no valid metadata.name")
+	}
+	envVars, err := BuildEnvVars([]Secret{*secret}, nil, opts)
+	if err != nil {
+		return fmt.Errorf("secret %s: %w", name, err)
+	}
+	InjectEnv(w, envVars, opts.Merge, opts.Containers)
+	return nil
+}
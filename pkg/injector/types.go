@@ -0,0 +1,204 @@
+// Package injector turns the keys of Kubernetes Secrets and ConfigMaps into container env vars
+// and injects them into workloads. The env-deployment-k8s command is a CLI around it.
+package injector
+
+type Secret struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Metadata   map[string]interface{} `yaml:"metadata"`
+	Data       map[string]string      `yaml:"data"`
+	StringData map[string]string      `yaml:"stringData"`
+	Type       string                 `yaml:"type,omitempty"`
+
+	// Keys lists the data keys followed by the stringData keys in the order they are declared
+	Keys []string `yaml:"-"`
+}
+
+type ConfigMap struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Metadata   map[string]interface{} `yaml:"metadata"`
+	Data       map[string]string      `yaml:"data"`
+
+	// Keys lists the data keys in the order they are declared
+	Keys []string `yaml:"-"`
+}
+
+type Deployment struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Metadata   map[string]interface{} `yaml:"metadata"`
+	Spec       DeploymentSpec         `yaml:"spec"`
+}
+
+type DeploymentSpec struct {
+	Replicas *int                   `yaml:"replicas,omitempty"`
+	Selector map[string]interface{} `yaml:"selector"`
+	Template PodTemplate            `yaml:"template"`
+}
+
+type StatefulSet struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Metadata   map[string]interface{} `yaml:"metadata"`
+	Spec       StatefulSetSpec        `yaml:"spec"`
+}
+
+type StatefulSetSpec struct {
+	Replicas    *int                   `yaml:"replicas,omitempty"`
+	ServiceName string                 `yaml:"serviceName"`
+	Selector    map[string]interface{} `yaml:"selector"`
+	Template    PodTemplate            `yaml:"template"`
+}
+
+type DaemonSet struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Metadata   map[string]interface{} `yaml:"metadata"`
+	Spec       DaemonSetSpec          `yaml:"spec"`
+}
+
+type DaemonSetSpec struct {
+	Selector map[string]interface{} `yaml:"selector"`
+	Template PodTemplate            `yaml:"template"`
+}
+
+type ReplicaSet struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Metadata   map[string]interface{} `yaml:"metadata"`
+	Spec       ReplicaSetSpec         `yaml:"spec"`
+}
+
+type ReplicaSetSpec struct {
+	Replicas *int                   `yaml:"replicas,omitempty"`
+	Selector map[string]interface{} `yaml:"selector"`
+	Template PodTemplate            `yaml:"template"`
+}
+
+type Job struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Metadata   map[string]interface{} `yaml:"metadata"`
+	Spec       JobSpec                `yaml:"spec"`
+}
+
+type JobSpec struct {
+	Template PodTemplate `yaml:"template"`
+}
+
+type CronJob struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Metadata   map[string]interface{} `yaml:"metadata"`
+	Spec       CronJobSpec            `yaml:"spec"`
+}
+
+type CronJobSpec struct {
+	Schedule    string      `yaml:"schedule"`
+	JobTemplate JobTemplate `yaml:"jobTemplate"`
+}
+
+// JobTemplate is the Job a CronJob creates on each run, its pod template sits one level deeper
+type JobTemplate struct {
+	Metadata map[string]interface{} `yaml:"metadata"`
+	Spec     JobSpec                `yaml:"spec"`
+}
+
+// Pod is a bare pod. It has no template: its own metadata and spec are what a template would hold.
+type Pod struct {
+	APIVersion  string `yaml:"apiVersion"`
+	Kind        string `yaml:"kind"`
+	PodTemplate `yaml:",inline"`
+}
+
+type PodTemplate struct {
+	Metadata map[string]interface{} `yaml:"metadata"`
+	Spec     PodSpec                `yaml:"spec"`
+}
+
+type PodSpec struct {
+	InitContainers   []Container            `yaml:"initContainers,omitempty"`
+	Containers       []Container            `yaml:"containers"`
+	ImagePullSecrets []LocalObjectReference `yaml:"imagePullSecrets,omitempty"`
+}
+
+// LocalObjectReference names an object in the same namespace, such as an image pull Secret
+type LocalObjectReference struct {
+	Name string `yaml:"name"`
+}
+
+type Container struct {
+	Name      string                `yaml:"name"`
+	Image     string                `yaml:"image"`
+	Ports     []Port                `yaml:"ports,omitempty"`
+	Env       []EnvVar              `yaml:"env,omitempty"`
+	EnvFrom   []EnvFromSource       `yaml:"envFrom,omitempty"`
+	Resources *ResourceRequirements `yaml:"resources,omitempty"`
+}
+
+// ResourceRequirements holds quantities such as 100m or 128Mi as written, claims are kept in Extra
+type ResourceRequirements struct {
+	Limits   map[string]string      `yaml:"limits,omitempty"`
+	Requests map[string]string      `yaml:"requests,omitempty"`
+	Extra    map[string]interface{} `yaml:",inline"`
+}
+
+type Port struct {
+	ContainerPort int `yaml:"containerPort"`
+}
+
+// EnvVar is a container env var, set either to a literal Value or from ValueFrom, never both.
+// Fields it doesn't model are kept in Extra so existing env vars survive a merge unchanged.
+type EnvVar struct {
+	Name      string                 `yaml:"name"`
+	Value     string                 `yaml:"value,omitempty"`
+	ValueFrom *ValueFromRef          `yaml:"valueFrom,omitempty"`
+	Extra     map[string]interface{} `yaml:",inline"`
+}
+
+type ValueFromRef struct {
+	SecretKeyRef     *SecretKeyRef          `yaml:"secretKeyRef,omitempty"`
+	ConfigMapKeyRef  *ConfigMapKeyRef       `yaml:"configMapKeyRef,omitempty"`
+	FieldRef         *FieldRef              `yaml:"fieldRef,omitempty"`
+	ResourceFieldRef *ResourceFieldRef      `yaml:"resourceFieldRef,omitempty"`
+	Extra            map[string]interface{} `yaml:",inline"`
+}
+
+// EnvFromSource injects every key of a Secret or ConfigMap into a container at once
+type EnvFromSource struct {
+	Prefix       string                 `yaml:"prefix,omitempty"`
+	SecretRef    *EnvFromRef            `yaml:"secretRef,omitempty"`
+	ConfigMapRef *EnvFromRef            `yaml:"configMapRef,omitempty"`
+	Extra        map[string]interface{} `yaml:",inline"`
+}
+
+type EnvFromRef struct {
+	Name     string `yaml:"name"`
+	Optional *bool  `yaml:"optional,omitempty"`
+}
+
+type SecretKeyRef struct {
+	Name     string `yaml:"name"`
+	Key      string `yaml:"key"`
+	Optional *bool  `yaml:"optional,omitempty"`
+}
+
+type ConfigMapKeyRef struct {
+	Name     string `yaml:"name"`
+	Key      string `yaml:"key"`
+	Optional *bool  `yaml:"optional,omitempty"`
+}
+
+// FieldRef exposes a field of the pod, such as metadata.name, as an env var
+type FieldRef struct {
+	APIVersion string `yaml:"apiVersion,omitempty"`
+	FieldPath  string `yaml:"fieldPath"`
+}
+
+// ResourceFieldRef exposes a container's resource request or limit as an env var
+type ResourceFieldRef struct {
+	ContainerName string `yaml:"containerName,omitempty"`
+	Resource      string `yaml:"resource"`
+	Divisor       string `yaml:"divisor,omitempty"`
+}
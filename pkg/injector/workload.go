@@ -0,0 +1,36 @@
+package injector
+
+// Workload is implemented by the resources whose pod template receives the env vars
+type Workload interface {
+	ObjectMeta() map[string]interface{}
+	Template() *PodTemplate
+	PodSpec() *PodSpec
+}
+
+func (d *Deployment) ObjectMeta() map[string]interface{} { return d.Metadata }
+func (d *Deployment) Template() *PodTemplate             { return &d.Spec.Template }
+func (d *Deployment) PodSpec() *PodSpec                  { return &d.Spec.Template.Spec }
+
+func (s *StatefulSet) ObjectMeta() map[string]interface{} { return s.Metadata }
+func (s *StatefulSet) Template() *PodTemplate             { return &s.Spec.Template }
+func (s *StatefulSet) PodSpec() *PodSpec                  { return &s.Spec.Template.Spec }
+
+func (d *DaemonSet) ObjectMeta() map[string]interface{} { return d.Metadata }
+func (d *DaemonSet) Template() *PodTemplate             { return &d.Spec.Template }
+func (d *DaemonSet) PodSpec() *PodSpec                  { return &d.Spec.Template.Spec }
+
+func (r *ReplicaSet) ObjectMeta() map[string]interface{} { return r.Metadata }
+func (r *ReplicaSet) Template() *PodTemplate             { return &r.Spec.Template }
+func (r *ReplicaSet) PodSpec() *PodSpec                  { return &r.Spec.Template.Spec }
+
+func (p *Pod) ObjectMeta() map[string]interface{} { return p.Metadata }
+func (p *Pod) Template() *PodTemplate             { return &p.PodTemplate }
+func (p *Pod) PodSpec() *PodSpec                  { return &p.Spec }
+
+func (j *Job) ObjectMeta() map[string]interface{} { return j.Metadata }
+func (j *Job) Template() *PodTemplate             { return &j.Spec.Template }
+func (j *Job) PodSpec() *PodSpec                  { return &j.Spec.Template.Spec }
+
+func (c *CronJob) ObjectMeta() map[string]interface{} { return c.Metadata }
+func (c *CronJob) Template() *PodTemplate             { return &c.Spec.JobTemplate.Spec.Template }
+func (c *CronJob) PodSpec() *PodSpec                  { return &c.Spec.JobTemplate.Spec.Template.Spec }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"env-deployment-k8s/pkg/injector"
+	"gopkg.in/yaml.v3"
+)
+
+// generatedAnnotation marks the workloads the tool wrote as JSON with -prune, so a later -prune run
+// only ever removes files it created. YAML files are recognized by their generatedHeader instead,
+// JSON files have no comment to carry it.
+const generatedAnnotation = "env-injector/generated"
+
+// workloadKey identifies a workload across runs by its kind, namespace and name
+func workloadKey(kind string, metadata map[string]interface{}) string {
+	name, _ := injector.MetadataString(metadata, "name")
+	return kind + "/" + namespaceOf(metadata) + "/" + name
+}
+
+// workloadKeys returns the keys of every workload found in the input, under the name it is written
+// with once -name-prefix and -name-suffix are put around it
+func workloadKeys(workloads []SourceWorkload, namePrefix, nameSuffix string) map[string]bool {
+	keys := make(map[string]bool, len(workloads))
+	for _, source := range workloads {
+		metadata := source.Workload.ObjectMeta()
+		name, _ := injector.MetadataString(metadata, "name")
+		keys[source.Kind+"/"+namespaceOf(metadata)+"/"+namePrefix+name+nameSuffix] = true
+	}
+	return keys
+}
+
+// pruneOutputs removes the generated files in the output directory whose workloads are no longer
+// in the input. Files without the generated annotation, and the input files themselves unless they
+// carry the generatedHeader of output written beside the inputs, are never removed. Nothing is pruned when some input could not be parsed, since its workloads are unknown.
+func pruneOutputs(current map[string]bool, inputs []string, opts *options, fails *failures) {
+	outputDir, combinedFile := outputLocation(opts)
+	if combinedFile != "" {
+		return
+	}
+	if !fails.complete() {
+		warnf("Not pruning %s: some input files were not processed\n", outputDir)
+		return
+	}
+
+	files, err := findYAMLFiles(outputDir, opts.preserveDirs, parseExtensions("yaml,yml,json"))
+	if err != nil {
+		fails.write("Failed to list output files in %s to prune: %v\n", outputDir, err)
+		return
+	}
+	isInput := make(map[string]bool, len(inputs))
+	for _, file := range inputs {
+		isInput[file] = true
+	}
+
+	for _, file := range files {
+		stale, err := staleOutput(file, current, isInput[file])
+		if err != nil {
+			debugf("Not pruning %s: %v\n", file, err)
+			continue
+		}
+		if !stale {
+			continue
+		}
+		if opts.dryRun {
+			infof("Would prune stale output file %s\n", file)
+			continue
+		}
+		err = os.Remove(file)
+		if err != nil {
+			fails.write("Failed to prune stale output file %s: %v\n", file, err)
+			continue
+		}
+		infof("Pruned stale output file %s\n", file)
+	}
+}
+
+// staleOutput reports whether a file is generated, by its header or the annotation on each of its workloads,
+// and holds no current workload. A file listed as input only counts as generated by its header.
+func staleOutput(file string, current map[string]bool, input bool) (bool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	data, _, err = decompress(data)
+	if err != nil {
+		return false, err
+	}
+
+	headed := hasGeneratedHeader(data)
+	if input && !headed {
+		return false, nil
+	}
+	workloads := 0
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var document struct {
+			Kind     string                 `yaml:"kind"`
+			Metadata map[string]interface{} `yaml:"metadata"`
+		}
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+
+		annotations, _ := document.Metadata["annotations"].(map[string]interface{})
+		if (!headed && annotations[generatedAnnotation] != "true") || current[workloadKey(document.Kind, document.Metadata)] {
+			return false, nil
+		}
+		workloads++
+	}
+	return workloads > 0, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPruneOutputs(t *testing.T) {
+	dir := t.TempDir()
+	deployment := func(name, annotations string) string {
+		return "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: " + name + "\n" + annotations
+	}
+	generated := "  annotations:\n    " + generatedAnnotation + ": \"true\"\n"
+	files := map[string]string{
+		"api_updated.yaml":      deployment("api", generated),
+		"old_updated.yaml":      deployment("old", generated),
+		"manual.yaml":           deployment("manual", ""),
+		"headed_updated.yaml":   generatedHeader + deployment("headed", ""),
+		"combined_updated.yaml": deployment("old", generated) + "---\n" + deployment("manual", ""),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	current := map[string]bool{"Deployment/default/api": true}
+	inputs := []string{filepath.Join(dir, "manual.yaml"), filepath.Join(dir, "headed_updated.yaml")}
+	pruneOutputs(current, inputs, &options{out: dir, format: "yaml"}, newFailures(false))
+
+	for name, want := range map[string]bool{"api_updated.yaml": true, "old_updated.yaml": false, "manual.yaml": true, "headed_updated.yaml": false, "combined_updated.yaml": true} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists = %v, want %v", name, exists, want)
+		}
+	}
+}
+
+func TestPruneOutputsIncompleteRun(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "old_updated.yaml")
+	data := "kind: Deployment\nmetadata:\n  name: old\n  annotations:\n    " + generatedAnnotation + ": \"true\"\n"
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fails := newFailures(false)
+	fails.parse("broken.yaml", "Failed to parse broken.yaml\n")
+	pruneOutputs(map[string]bool{}, nil, &options{out: dir, format: "yaml"}, fails)
+
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("old_updated.yaml was pruned after a failed parse: %v", err)
+	}
+}
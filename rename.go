@@ -0,0 +1,94 @@
+package main
+
+import (
+	"env-deployment-k8s/pkg/injector"
+	"gopkg.in/yaml.v3"
+)
+
+// renameWorkload puts the -name-prefix and -name-suffix around the workload's name. Labels holding
+// the old name, such as app: api, are renamed along with it in the workload's own labels, the pod
+// template's and the selector's matchLabels, so the selector keeps matching the copy's pods only.
+func renameWorkload(source SourceWorkload, prefix, suffix string) {
+	if prefix == "" && suffix == "" {
+		return
+	}
+	metadata := source.Workload.ObjectMeta()
+	oldName, ok := injector.MetadataString(metadata, "name")
+	if !ok {
+		warnf("%s in file %s has no metadata.name: not renaming it\n", source.Kind, source.File)
+		return
+	}
+	newName := prefix + oldName + suffix
+	metadata["name"] = newName
+
+	labelMaps := []interface{}{metadata["labels"], source.Workload.Template().Metadata["labels"]}
+	if selector := selectorOf(source.Workload); selector != nil {
+		labelMaps = append(labelMaps, selector["matchLabels"])
+	}
+	for _, labels := range labelMaps {
+		labels, _ := labels.(map[string]interface{})
+		for key, value := range labels {
+			if value == oldName {
+				labels[key] = newName
+			}
+		}
+	}
+	debugf("Renamed %s %s to %s\n", source.Kind, oldName, newName)
+}
+
+// selectorOf returns spec.selector of the kinds that model it, nil for the others
+func selectorOf(w workload) map[string]interface{} {
+	switch w := w.(type) {
+	case *Deployment:
+		return w.Spec.Selector
+	case *StatefulSet:
+		return w.Spec.Selector
+	case *DaemonSet:
+		return w.Spec.Selector
+	case *ReplicaSet:
+		return w.Spec.Selector
+	}
+	return nil
+}
+
+// writeNames copies the workload's name and the labels renameWorkload may have changed into its document node
+func writeNames(source SourceWorkload, templateNode *yaml.Node) error {
+	metadata := source.Workload.ObjectMeta()
+	if name, ok := injector.MetadataString(metadata, "name"); ok {
+		current := lookupNode(source.Node, "metadata", "name")
+		if current != nil && current.Kind == yaml.ScalarNode {
+			// Keep the node, and its comments, when only the value changes
+			current.Value = name
+		} else {
+			metadataNode, err := mappingChild(source.Node, "metadata")
+			if err != nil {
+				return err
+			}
+			err = setNodeValue(metadataNode, "name", name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	labels, _ := metadata["labels"].(map[string]interface{})
+	err := writeStrings(source.Node, labels, "metadata", "labels")
+	if err != nil {
+		return err
+	}
+	if templateNode != nil {
+		labels, _ = source.Workload.Template().Metadata["labels"].(map[string]interface{})
+		err = writeStrings(templateNode, labels, "metadata", "labels")
+		if err != nil {
+			return err
+		}
+	}
+	if selector := selectorOf(source.Workload); selector != nil {
+		matchLabels, _ := selector["matchLabels"].(map[string]interface{})
+		err = writeStrings(source.Node, matchLabels, "spec", "selector", "matchLabels")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
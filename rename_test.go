@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenameWorkload(t *testing.T) {
+	input := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api # the API
+  labels:
+    app: api
+    tier: backend
+spec:
+  selector:
+    matchLabels:
+      app: api
+  template:
+    metadata:
+      labels:
+        app: api
+        tier: backend
+    spec:
+      containers:
+        - name: api
+`
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(input), &node); err != nil {
+		t.Fatal(err)
+	}
+	w, err := decodeWorkload("Deployment", &node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := SourceWorkload{Kind: "Deployment", Workload: w, Node: &node}
+
+	renameWorkload(source, "pr-42-", "")
+	out, err := marshalWorkload(source, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(out)
+	if strings.Contains(got, "app: api\n") {
+		t.Errorf("output still has a label holding the old name:\n%s", got)
+	}
+	for _, want := range []string{"name: pr-42-api # the API", "tier: backend", "- name: api\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+	if n := strings.Count(got, "app: pr-42-api"); n != 3 {
+		t.Errorf("output has %d renamed app labels, want 3:\n%s", n, got)
+	}
+}
+
+func TestWorkloadKeysRenamed(t *testing.T) {
+	workloads := []SourceWorkload{{
+		Kind:     "Deployment",
+		Workload: &Deployment{Metadata: map[string]interface{}{"name": "api"}},
+	}}
+
+	keys := workloadKeys(workloads, "pr-42-", "-copy")
+	if !keys["Deployment/default/pr-42-api-copy"] || len(keys) != 1 {
+		t.Errorf("workloadKeys() = %v, want the renamed key only", keys)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// report is the machine-readable outcome of a run written with -report
+type report struct {
+	Success  bool         `json:"success"`
+	Files    []reportFile `json:"files"`
+	Skipped  []reportSkip `json:"skipped"`
+	Injected []summaryRow `json:"injected"`
+	Warnings []string     `json:"warnings"`
+	Errors   []string     `json:"errors"`
+}
+
+// reportFile is an input file and whether it could be read and parsed
+type reportFile struct {
+	File   string `json:"file"`
+	Failed bool   `json:"failed"`
+}
+
+// reportSkip is a document or workload left out of the run and why
+type reportSkip struct {
+	File   string `json:"file"`
+	Kind   string `json:"kind,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// buildReport gathers the outcome of the run, which succeeds as the exit status says, stricter for a -check.
+// Lists are never null so consumers can iterate them as they are.
+func buildReport(files []string, summary []summaryRow, fails *failures, check bool) report {
+	fails.mu.Lock()
+	defer fails.mu.Unlock()
+
+	r := report{
+		Success:  !fails.failed(check),
+		Files:    make([]reportFile, 0, len(files)),
+		Skipped:  append([]reportSkip{}, fails.skipped...),
+		Injected: append([]summaryRow{}, summary...),
+		Warnings: append([]string{}, fails.warnings...),
+		Errors:   append([]string{}, fails.errors...),
+	}
+	// The parse workers record skips in whatever order they finish
+	sort.SliceStable(r.Skipped, func(i, j int) bool { return r.Skipped[i].File < r.Skipped[j].File })
+	for _, file := range files {
+		r.Files = append(r.Files, reportFile{File: file, Failed: fails.files[file]})
+	}
+	return r
+}
+
+// writeReport saves the report of the run as indented JSON
+func writeReport(path string, files []string, summary []summaryRow, fails *failures, check bool) error {
+	data, err := json.MarshalIndent(buildReport(files, summary, fails, check), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildReport(t *testing.T) {
+	fails := newFailures(false)
+	fails.parse("b.yaml", "Failed to parse YAML document 1 in file %s\n", "b.yaml")
+	fails.skip("c.yaml", "Service", "", "document 1 is not a supported kind")
+	fails.skip("a.yaml", "Deployment", "api", "labels don't match -select")
+	summary := []summaryRow{{Kind: "Deployment", Workload: "web", Container: "app", Count: 2}}
+
+	got := buildReport([]string{"a.yaml", "b.yaml"}, summary, fails, false)
+	want := report{
+		Success: false,
+		Files:   []reportFile{{File: "a.yaml"}, {File: "b.yaml", Failed: true}},
+		Skipped: []reportSkip{
+			{File: "a.yaml", Kind: "Deployment", Name: "api", Reason: "labels don't match -select"},
+			{File: "c.yaml", Kind: "Service", Reason: "document 1 is not a supported kind"},
+		},
+		Injected: summary,
+		Warnings: []string{},
+		Errors:   []string{"Failed to parse YAML document 1 in file b.yaml"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildReport() = %+v, want %+v", got, want)
+	}
+}
+
+func TestBuildReportSuccess(t *testing.T) {
+	refused := newFailures(false)
+	refused.refuse("a.yaml", "Deployment", "api", "No Secret or ConfigMap in namespace prod for Deployment api\n")
+	warned := newFailures(false)
+	warned.note("Deployment api has apiVersion extensions/v1beta1\n")
+
+	tests := []struct {
+		name  string
+		fails *failures
+		check bool
+		want  bool
+	}{
+		{"refused", refused, false, false},
+		{"warning", warned, false, true},
+		{"warning under -check", warned, true, false},
+		{"clean -check", newFailures(false), true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildReport(nil, nil, tt.fails, tt.check).Success; got != tt.want {
+				t.Errorf("Success = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
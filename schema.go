@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// schema lists the fields allowed in a mapping, each with the schema of its value. A nil schema
+// accepts any value. Sequences are checked item by item against the same schema.
+type schema map[string]schema
+
+// fields returns a schema allowing the named fields with any value
+func fields(names ...string) schema {
+	s := make(schema, len(names))
+	for _, name := range names {
+		s[name] = nil
+	}
+	return s
+}
+
+// with returns the schema with the given fields checked against their own schemas
+func (s schema) with(children map[string]schema) schema {
+	for name, child := range children {
+		s[name] = child
+	}
+	return s
+}
+
+// The field names below follow the Kubernetes OpenAPI schema for the kinds the tool processes. Only
+// the structure down to the containers' env is bundled, which is where typos go unnoticed by the
+// tool; deeper objects such as probes or volumes accept anything.
+var (
+	objectMetaSchema = fields("annotations", "creationTimestamp", "deletionGracePeriodSeconds", "deletionTimestamp",
+		"finalizers", "generateName", "generation", "labels", "managedFields", "name", "namespace",
+		"ownerReferences", "resourceVersion", "selfLink", "uid")
+
+	keyRefSchema = fields("name", "key", "optional")
+
+	envVarSchema = fields("name", "value", "valueFrom").with(map[string]schema{
+		"valueFrom": fields("configMapKeyRef", "fieldRef", "resourceFieldRef", "secretKeyRef").with(map[string]schema{
+			"configMapKeyRef":  keyRefSchema,
+			"secretKeyRef":     keyRefSchema,
+			"fieldRef":         fields("apiVersion", "fieldPath"),
+			"resourceFieldRef": fields("containerName", "divisor", "resource"),
+		}),
+	})
+
+	envFromSchema = fields("prefix", "configMapRef", "secretRef").with(map[string]schema{
+		"configMapRef": fields("name", "optional"),
+		"secretRef":    fields("name", "optional"),
+	})
+
+	containerSchema = fields("args", "command", "env", "envFrom", "image", "imagePullPolicy", "lifecycle",
+		"livenessProbe", "name", "ports", "readinessProbe", "resizePolicy", "resources", "restartPolicy",
+		"securityContext", "startupProbe", "stdin", "stdinOnce", "terminationMessagePath",
+		"terminationMessagePolicy", "tty", "volumeDevices", "volumeMounts", "workingDir").with(map[string]schema{
+		"env":     envVarSchema,
+		"envFrom": envFromSchema,
+		"ports":   fields("containerPort", "hostIP", "hostPort", "name", "protocol"),
+	})
+
+	podSpecSchema = fields("activeDeadlineSeconds", "affinity", "automountServiceAccountToken", "containers",
+		"dnsConfig", "dnsPolicy", "enableServiceLinks", "ephemeralContainers", "hostAliases", "hostIPC",
+		"hostNetwork", "hostPID", "hostUsers", "hostname", "imagePullSecrets", "initContainers", "nodeName",
+		"nodeSelector", "os", "overhead", "preemptionPolicy", "priority", "priorityClassName", "readinessGates",
+		"resourceClaims", "resources", "restartPolicy", "runtimeClassName", "schedulerName", "schedulingGates",
+		"securityContext", "serviceAccount", "serviceAccountName", "setHostnameAsFQDN", "shareProcessNamespace",
+		"subdomain", "terminationGracePeriodSeconds", "tolerations", "topologySpreadConstraints", "volumes").with(map[string]schema{
+		"containers":     containerSchema,
+		"initContainers": containerSchema,
+	})
+
+	podTemplateSchema = fields("metadata", "spec").with(map[string]schema{
+		"metadata": objectMetaSchema,
+		"spec":     podSpecSchema,
+	})
+
+	jobSpecSchema = fields("activeDeadlineSeconds", "backoffLimit", "backoffLimitPerIndex", "completionMode",
+		"completions", "managedBy", "manualSelector", "maxFailedIndexes", "parallelism", "podFailurePolicy",
+		"podReplacementPolicy", "selector", "successPolicy", "suspend", "template", "ttlSecondsAfterFinished").with(map[string]schema{
+		"template": podTemplateSchema,
+	})
+
+	kindSpecSchemas = map[string]schema{
+		"Deployment": fields("minReadySeconds", "paused", "progressDeadlineSeconds", "replicas",
+			"revisionHistoryLimit", "selector", "strategy", "template").with(map[string]schema{
+			"template": podTemplateSchema,
+		}),
+		"StatefulSet": fields("minReadySeconds", "ordinals", "persistentVolumeClaimRetentionPolicy",
+			"podManagementPolicy", "replicas", "revisionHistoryLimit", "selector", "serviceName", "template",
+			"updateStrategy", "volumeClaimTemplates").with(map[string]schema{
+			"template": podTemplateSchema,
+		}),
+		"DaemonSet": fields("minReadySeconds", "revisionHistoryLimit", "selector", "template", "updateStrategy").with(map[string]schema{
+			"template": podTemplateSchema,
+		}),
+		"ReplicaSet": fields("minReadySeconds", "replicas", "selector", "template").with(map[string]schema{
+			"template": podTemplateSchema,
+		}),
+		"Pod": podSpecSchema,
+		"Job": jobSpecSchema,
+		"CronJob": fields("concurrencyPolicy", "failedJobsHistoryLimit", "jobTemplate", "schedule",
+			"startingDeadlineSeconds", "successfulJobsHistoryLimit", "suspend", "timeZone").with(map[string]schema{
+			"jobTemplate": fields("metadata", "spec").with(map[string]schema{
+				"metadata": objectMetaSchema,
+				"spec":     jobSpecSchema,
+			}),
+		}),
+	}
+)
+
+// kindSchema returns the schema of a whole document of the given kind
+func kindSchema(kind string) schema {
+	switch kind {
+	case "Secret":
+		return fields("apiVersion", "kind", "metadata", "data", "stringData", "type", "immutable").with(map[string]schema{
+			"metadata": objectMetaSchema,
+		})
+	case "ConfigMap":
+		return fields("apiVersion", "kind", "metadata", "data", "binaryData", "immutable").with(map[string]schema{
+			"metadata": objectMetaSchema,
+		})
+	default:
+		return fields("apiVersion", "kind", "metadata", "spec", "status").with(map[string]schema{
+			"metadata": objectMetaSchema,
+			"spec":     kindSpecSchemas[kind],
+		})
+	}
+}
+
+// schemaProblems reports every field of the document that its kind's schema doesn't allow, with its line
+func schemaProblems(node *yaml.Node, kind string) []string {
+	var problems []string
+	checkSchema(unwrapDocument(node), kindSchema(kind), "", &problems)
+	return problems
+}
+
+// checkSchema walks a node along its schema, collecting the unknown fields under path
+func checkSchema(node *yaml.Node, s schema, path string, problems *[]string) {
+	if s == nil || node == nil {
+		return
+	}
+
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			checkSchema(item, s, fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			fieldPath := key.Value
+			if path != "" {
+				fieldPath = path + "." + key.Value
+			}
+			child, ok := s[key.Value]
+			if !ok {
+				*problems = append(*problems, fmt.Sprintf("line %d: unknown field %s", key.Line, fieldPath))
+				continue
+			}
+			checkSchema(node.Content[i+1], child, fieldPath, problems)
+		}
+	}
+}
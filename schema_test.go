@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSchemaProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		kind string
+		data string
+		want []string
+	}{
+		{
+			"valid deployment",
+			"Deployment",
+			"apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\nspec:\n  replicas: 2\n  template:\n    spec:\n      containers:\n        - name: app\n          env:\n            - name: A\n              valueFrom:\n                secretKeyRef: {name: s, key: a}\n",
+			nil,
+		},
+		{
+			"misspelled containers",
+			"Deployment",
+			"apiVersion: apps/v1\nkind: Deployment\nspec:\n  template:\n    spec:\n      contaienrs:\n        - name: app\n",
+			[]string{"line 6: unknown field spec.template.spec.contaienrs"},
+		},
+		{
+			"misspelled env field in cronjob",
+			"CronJob",
+			"apiVersion: batch/v1\nkind: CronJob\nspec:\n  jobTemplate:\n    spec:\n      template:\n        spec:\n          containers:\n            - name: job\n              env:\n                - name: A\n                  vaule: b\n",
+			[]string{"line 12: unknown field spec.jobTemplate.spec.template.spec.containers[0].env[0].vaule"},
+		},
+		{
+			"secret",
+			"Secret",
+			"apiVersion: v1\nkind: Secret\nmetadata:\n  name: s\nstringdata:\n  a: b\n",
+			[]string{"line 5: unknown field stringdata"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var node yaml.Node
+			if err := yaml.Unmarshal([]byte(tt.data), &node); err != nil {
+				t.Fatal(err)
+			}
+			got := schemaProblems(&node, tt.kind)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("schemaProblems() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
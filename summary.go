@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// summaryRow is the number of env vars injected into one container of a workload
+type summaryRow struct {
+	Kind      string `json:"kind"`
+	Workload  string `json:"workload"`
+	Container string `json:"container"`
+	Count     int    `json:"count"`
+}
+
+// printSummary lists the env vars injected per container and the total across all workloads
+func printSummary(w io.Writer, rows []summaryRow) {
+	fmt.Fprintln(w, "Summary:")
+
+	total := 0
+	for _, row := range rows {
+		fmt.Fprintf(w, "  %s %s, container %s: %d env vars\n", row.Kind, row.Workload, row.Container, row.Count)
+		total += row.Count
+	}
+
+	fmt.Fprintf(w, "Total: %d env vars injected into %d containers\n", total, len(rows))
+}
+
+// logSummary is printSummary for -log-format json: an entry for each container, with the kind and
+// name of its workload as fields, and one for the total
+func logSummary(rows []summaryRow) {
+	total := 0
+	for _, row := range rows {
+		logf("info", "", logFields{Kind: row.Kind, Name: row.Workload}, "Injected %d env vars into container %s\n", row.Count, row.Container)
+		total += row.Count
+	}
+	logf("info", "", logFields{}, "Total: %d env vars injected into %d containers\n", total, len(rows))
+}
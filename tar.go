@@ -0,0 +1,92 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// parseTar reads the manifests of a -tar archive, plain or gzip-compressed, without extracting it.
+// Every regular file with one of the -ext extensions is parsed in memory under the name
+// archive/entry, everything else in the archive is skipped. It returns the entry names for the report.
+func parseTar(ctx context.Context, archive string, opts *options, fails *failures) ([]string, *Manifests, error) {
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, _, err = decompress(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	extensions := parseExtensions(opts.ext)
+	manifests := &Manifests{}
+	var files []string
+	reader := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		file := filepath.Join(archive, filepath.FromSlash(path.Clean(header.Name)))
+		if !isManifestFile(header.Name, extensions) {
+			debugf("Skipping %s: not a manifest file\n", file)
+			continue
+		}
+		if matchesAny(path.Base(header.Name), opts.excludes) {
+			debugf("Excluding file: %s\n", file)
+			continue
+		}
+		files = append(files, file)
+
+		if ctx.Err() != nil {
+			errorf("Timed out: skipping %s\n", file)
+			fails.timeout(file, "", "")
+			continue
+		}
+		if opts.maxFileSize > 0 && header.Size > opts.maxFileSize {
+			fails.warn("File %s is %d bytes, larger than -max-file-size %d: skipping\n", file, header.Size, opts.maxFileSize)
+			fails.skip(file, "", "", fmt.Sprintf("larger than -max-file-size %d bytes", opts.maxFileSize))
+			continue
+		}
+
+		debugf("Processing file: %s\n", file)
+		entry, err := io.ReadAll(reader)
+		if err != nil {
+			fails.parse(file, "Failed to read file %s: %v\n", file, err)
+			continue
+		}
+		entry, compressed, err := decompress(entry)
+		if err != nil {
+			fails.parse(file, "Failed to decompress file %s: %v\n", file, err)
+			continue
+		}
+
+		parsed := &Manifests{}
+		parsed.parseFile(file, entry, opts, fails)
+		for i := range parsed.Workloads {
+			parsed.Workloads[i].Compressed = compressed
+		}
+		manifests.Secrets = append(manifests.Secrets, parsed.Secrets...)
+		manifests.ConfigMaps = append(manifests.ConfigMaps, parsed.ConfigMaps...)
+		manifests.Workloads = append(manifests.Workloads, parsed.Workloads...)
+	}
+
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("no files with extension %s found", opts.ext)
+	}
+	return files, manifests, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTar(t *testing.T) {
+	entries := []struct {
+		name string
+		data string
+	}{
+		{"manifests/secret.yaml", "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app-secret\nstringData:\n  token: abc\n"},
+		{"manifests/app/deployment.yml", "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\nspec:\n  template:\n    spec:\n      containers:\n        - name: app\n"},
+		{"manifests/README.md", "# not a manifest\n"},
+	}
+
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		if err := writer.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(entry.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(t.TempDir(), "bundle.tar")
+	if err := os.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, m, err := parseTar(context.Background(), archive, &options{ext: "yaml,yml"}, newFailures(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || len(m.Secrets) != 1 || len(m.Workloads) != 1 {
+		t.Fatalf("parseTar() = %v with %d Secrets and %d workloads, want 2 files, 1 Secret and 1 workload", files, len(m.Secrets), len(m.Workloads))
+	}
+	if want := filepath.Join(archive, "manifests", "app", "deployment.yml"); m.Workloads[0].File != want {
+		t.Errorf("workload file = %s, want %s", m.Workloads[0].File, want)
+	}
+
+	if _, _, err := parseTar(context.Background(), archive, &options{ext: "json"}, newFailures(false)); err == nil {
+		t.Error("parseTar() expected an error for an archive without matching files")
+	}
+}
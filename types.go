@@ -0,0 +1,28 @@
+package main
+
+import "env-deployment-k8s/pkg/injector"
+
+// The manifest types live in the injector package, which other programs can import
+type (
+	workload = injector.Workload
+
+	Secret               = injector.Secret
+	ConfigMap            = injector.ConfigMap
+	Deployment           = injector.Deployment
+	StatefulSet          = injector.StatefulSet
+	DaemonSet            = injector.DaemonSet
+	ReplicaSet           = injector.ReplicaSet
+	Job                  = injector.Job
+	CronJob              = injector.CronJob
+	Pod                  = injector.Pod
+	PodTemplate          = injector.PodTemplate
+	PodSpec              = injector.PodSpec
+	Container            = injector.Container
+	ResourceRequirements = injector.ResourceRequirements
+	EnvVar               = injector.EnvVar
+	ValueFromRef         = injector.ValueFromRef
+	EnvFromSource        = injector.EnvFromSource
+	SecretKeyRef         = injector.SecretKeyRef
+	ConfigMapKeyRef      = injector.ConfigMapKeyRef
+	Port                 = injector.Port
+)
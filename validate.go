@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"env-deployment-k8s/pkg/injector"
+)
+
+// portProblems checks that every containerPort is in the valid range and that no two
+// containers in the pod declare the same port
+func portProblems(podSpec *PodSpec) []string {
+	var problems []string
+	owners := make(map[int]string)
+
+	for _, container := range podSpec.Containers {
+		for _, port := range container.Ports {
+			if port.ContainerPort < 1 || port.ContainerPort > 65535 {
+				problems = append(problems, fmt.Sprintf("container %s declares containerPort %d outside 1-65535", container.Name, port.ContainerPort))
+				continue
+			}
+			if owner, ok := owners[port.ContainerPort]; ok {
+				problems = append(problems, fmt.Sprintf("containerPort %d is declared by both container %s and container %s", port.ContainerPort, owner, container.Name))
+				continue
+			}
+			owners[port.ContainerPort] = container.Name
+		}
+	}
+
+	return problems
+}
+
+// danglingKeyRefs checks that every secretKeyRef already in the containers' env points to a key
+// of the Secret it names. Optional references and references to Secrets that weren't parsed
+// can't go wrong here and are left alone.
+func danglingKeyRefs(podSpec *PodSpec, secrets []Secret) []string {
+	keys := make(map[string]map[string]bool)
+	for i := range secrets {
+		name, _ := injector.MetadataString(secrets[i].Metadata, "name")
+		keys[name] = make(map[string]bool)
+		for _, key := range injector.SecretKeys(&secrets[i]) {
+			keys[name][key] = true
+		}
+	}
+
+	var problems []string
+	for _, containers := range [][]Container{podSpec.InitContainers, podSpec.Containers} {
+		for _, container := range containers {
+			for _, env := range container.Env {
+				if env.ValueFrom == nil || env.ValueFrom.SecretKeyRef == nil {
+					continue
+				}
+				ref := env.ValueFrom.SecretKeyRef
+				if ref.Optional != nil && *ref.Optional {
+					continue
+				}
+				if known, ok := keys[ref.Name]; ok && !known[ref.Key] {
+					problems = append(problems, fmt.Sprintf("env var %s in container %s references key %s missing from Secret %s", env.Name, container.Name, ref.Key, ref.Name))
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// validateWorkload reports the problems of a workload found before anything is injected, returning
+// false when the workload has no containers to inject into
+func validateWorkload(source SourceWorkload, injector *envInjector, opts *options, fails *failures) bool {
+	// A missing or empty containers list, usually from bad indentation, leaves nothing to inject into
+	if len(source.Workload.PodSpec().Containers) == 0 {
+		path := strings.Join(append(podSpecPath(source.Kind), "containers"), ".")
+		fails.warn("%s %s in file %s has no containers under %s: skipping\n", source.Kind, workloadName(source), source.File, path)
+		fails.skip(source.File, source.Kind, workloadName(source), "no containers under "+path)
+		return false
+	}
+
+	// Catch port typos and clashes before anything is written
+	for _, problem := range portProblems(source.Workload.PodSpec()) {
+		fails.warn("%s in file %s: %s\n", source.Kind, source.File, problem)
+	}
+
+	// The existing env vars stay with -merge, and -check looks at them as they are, so make sure
+	// their secretKeyRefs still resolve
+	if opts.merge || opts.check {
+		namespace := namespaceOf(source.Workload.ObjectMeta())
+		for _, problem := range danglingKeyRefs(source.Workload.PodSpec(), injector.sourcesIn(namespace).secrets) {
+			fails.warn("%s %s in file %s: %s\n", source.Kind, workloadName(source), source.File, problem)
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPortProblems(t *testing.T) {
+	tests := []struct {
+		name       string
+		containers []Container
+		want       int
+	}{
+		{
+			name:       "valid ports",
+			containers: []Container{{Name: "app", Ports: []Port{{ContainerPort: 8080}}}, {Name: "sidecar", Ports: []Port{{ContainerPort: 9090}}}},
+		},
+		{
+			name:       "out of range",
+			containers: []Container{{Name: "app", Ports: []Port{{ContainerPort: 80800}, {ContainerPort: 0}}}},
+			want:       2,
+		},
+		{
+			name:       "duplicate across containers",
+			containers: []Container{{Name: "app", Ports: []Port{{ContainerPort: 8080}}}, {Name: "sidecar", Ports: []Port{{ContainerPort: 8080}}}},
+			want:       1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := portProblems(&PodSpec{Containers: tt.containers})
+			if len(got) != tt.want {
+				t.Errorf("portProblems() = %v, want %d problems", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDanglingKeyRefs(t *testing.T) {
+	optional := true
+	secrets := []Secret{{Metadata: map[string]interface{}{"name": "app-secret"}, Data: map[string]string{"api_key": ""}}}
+	env := []EnvVar{
+		secretEnv("API_KEY", "app-secret", "api_key"),
+		secretEnv("OLD_TOKEN", "app-secret", "token"),
+		secretEnv("OTHER", "unknown-secret", "token"),
+		{Name: "OPTIONAL", ValueFrom: &ValueFromRef{SecretKeyRef: &SecretKeyRef{Name: "app-secret", Key: "gone", Optional: &optional}}},
+		{Name: "PLAIN"},
+	}
+
+	got := danglingKeyRefs(&PodSpec{Containers: []Container{{Name: "app", Env: env}}}, secrets)
+	if len(got) != 1 || !strings.Contains(got[0], "OLD_TOKEN") {
+		t.Errorf("danglingKeyRefs() = %v, want only OLD_TOKEN reported", got)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"env-deployment-k8s/pkg/injector"
+	"gopkg.in/yaml.v3"
+)
+
+// decodeWorkload decodes a document node into the struct for its kind
+func decodeWorkload(kind string, node *yaml.Node) (workload, error) {
+	var w workload
+	switch kind {
+	case "Deployment":
+		w = &Deployment{}
+	case "StatefulSet":
+		w = &StatefulSet{}
+	case "DaemonSet":
+		w = &DaemonSet{}
+	case "ReplicaSet":
+		w = &ReplicaSet{}
+	case "Pod":
+		w = &Pod{}
+	case "Job":
+		w = &Job{}
+	case "CronJob":
+		w = &CronJob{}
+	default:
+		return nil, fmt.Errorf("unsupported workload kind %s", kind)
+	}
+
+	err := node.Decode(w)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// podTemplatePath returns the mapping keys leading from the document root to the pod template of a kind.
+// A Pod is its own template, so the path to it is empty.
+func podTemplatePath(kind string) []string {
+	switch kind {
+	case "CronJob":
+		return []string{"spec", "jobTemplate", "spec", "template"}
+	case "Pod":
+		return nil
+	}
+	return []string{"spec", "template"}
+}
+
+// podSpecPath returns the mapping keys leading from the document root to the pod spec of a kind
+func podSpecPath(kind string) []string {
+	return append(podTemplatePath(kind), "spec")
+}
+
+// selectWorkloads keeps the workloads whose metadata.labels contain every selector,
+// reporting the others as skipped
+func selectWorkloads(workloads []SourceWorkload, selectors map[string]string) []SourceWorkload {
+	if len(selectors) == 0 {
+		return workloads
+	}
+
+	var selected []SourceWorkload
+	for _, source := range workloads {
+		if matchesLabels(source.Workload.ObjectMeta(), selectors) {
+			selected = append(selected, source)
+			continue
+		}
+		infof("Skipping %s %s in file %s: labels don't match -select\n", source.Kind, workloadName(source), source.File)
+	}
+	return selected
+}
+
+// skipAnnotated drops the workloads that opt out of injection with the annotation set to true,
+// reporting each of them as skipped
+func skipAnnotated(workloads []SourceWorkload, annotation string) []SourceWorkload {
+	if annotation == "" {
+		return workloads
+	}
+
+	var kept []SourceWorkload
+	for _, source := range workloads {
+		annotations, _ := source.Workload.ObjectMeta()["annotations"].(map[string]interface{})
+		value, _ := annotations[annotation].(string)
+		if skip, _ := strconv.ParseBool(value); skip {
+			logFields{File: source.File, Kind: source.Kind, Name: workloadName(source)}.infof("Skipping %s %s in file %s: annotated %s\n", source.Kind, workloadName(source), source.File, annotation)
+			continue
+		}
+		kept = append(kept, source)
+	}
+	return kept
+}
+
+// matchesLabels reports whether metadata.labels contains every key with the same value
+func matchesLabels(metadata map[string]interface{}, selectors map[string]string) bool {
+	labels, _ := metadata["labels"].(map[string]interface{})
+	for key, value := range selectors {
+		label, ok := labels[key].(string)
+		if !ok || label != value {
+			return false
+		}
+	}
+	return true
+}
+
+// overrideImages sets the image of every container named in images and returns the names it matched
+func overrideImages(podSpec *PodSpec, images map[string]string) []string {
+	var matched []string
+	for _, containers := range [][]Container{podSpec.InitContainers, podSpec.Containers} {
+		for i := range containers {
+			if image, ok := images[containers[i].Name]; ok {
+				containers[i].Image = image
+				matched = append(matched, containers[i].Name)
+			}
+		}
+	}
+	return matched
+}
+
+// setReplicas sets spec.replicas on the kinds that have it, reporting false for the others such as DaemonSets
+func setReplicas(w workload, replicas int) bool {
+	switch w := w.(type) {
+	case *Deployment:
+		w.Spec.Replicas = &replicas
+	case *StatefulSet:
+		w.Spec.Replicas = &replicas
+	case *ReplicaSet:
+		w.Spec.Replicas = &replicas
+	default:
+		return false
+	}
+	return true
+}
+
+// replicasOf returns spec.replicas of the kinds that have it, nil when it is unset or for the other kinds
+func replicasOf(w workload) *int {
+	switch w := w.(type) {
+	case *Deployment:
+		return w.Spec.Replicas
+	case *StatefulSet:
+		return w.Spec.Replicas
+	case *ReplicaSet:
+		return w.Spec.Replicas
+	}
+	return nil
+}
+
+// applyDefaultResources fills in the requests and limits every container leaves unset, never
+// changing a value that is already there
+func applyDefaultResources(podSpec *PodSpec, requests, limits map[string]string) {
+	if len(requests) == 0 && len(limits) == 0 {
+		return
+	}
+	for _, containers := range [][]Container{podSpec.InitContainers, podSpec.Containers} {
+		for i := range containers {
+			if containers[i].Resources == nil {
+				containers[i].Resources = &ResourceRequirements{}
+			}
+			containers[i].Resources.Requests = withDefaults(containers[i].Resources.Requests, requests)
+			containers[i].Resources.Limits = withDefaults(containers[i].Resources.Limits, limits)
+		}
+	}
+}
+
+// withDefaults adds the defaults missing from values, allocating values when needed
+func withDefaults(values, defaults map[string]string) map[string]string {
+	for name, quantity := range defaults {
+		if _, ok := values[name]; ok {
+			continue
+		}
+		if values == nil {
+			values = make(map[string]string)
+		}
+		values[name] = quantity
+	}
+	return values
+}
+
+// sortWorkloads orders workloads by metadata.name, then by source file, so output doesn't
+// depend on the order the filesystem lists files in
+func sortWorkloads(workloads []SourceWorkload) {
+	sort.SliceStable(workloads, func(i, j int) bool {
+		nameI, _ := injector.MetadataString(workloads[i].Workload.ObjectMeta(), "name")
+		nameJ, _ := injector.MetadataString(workloads[j].Workload.ObjectMeta(), "name")
+		if nameI != nameJ {
+			return nameI < nameJ
+		}
+		return workloads[i].File < workloads[j].File
+	})
+}
+
+// sortContainers orders the pod's containers by name, in the workload and in its document node alike
+// since containers are matched to their nodes by position
+func sortContainers(source SourceWorkload) {
+	containers := source.Workload.PodSpec().Containers
+	order := make([]int, len(containers))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return containers[order[i]].Name < containers[order[j]].Name
+	})
+
+	sorted := make([]Container, len(containers))
+	for i, from := range order {
+		sorted[i] = containers[from]
+	}
+	copy(containers, sorted)
+
+	containersNode := lookupNode(source.Node, append(podSpecPath(source.Kind), "containers")...)
+	if containersNode == nil || containersNode.Kind != yaml.SequenceNode || len(containersNode.Content) != len(containers) {
+		return
+	}
+	sortedNodes := make([]*yaml.Node, len(order))
+	for i, from := range order {
+		sortedNodes[i] = containersNode.Content[from]
+	}
+	copy(containersNode.Content, sortedNodes)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMatchesLabels(t *testing.T) {
+	metadata := map[string]interface{}{
+		"labels": map[string]interface{}{"team": "payments", "tier": "backend"},
+	}
+
+	tests := []struct {
+		name      string
+		selectors map[string]string
+		want      bool
+	}{
+		{"no selectors", nil, true},
+		{"single match", map[string]string{"team": "payments"}, true},
+		{"all match", map[string]string{"team": "payments", "tier": "backend"}, true},
+		{"one mismatch", map[string]string{"team": "payments", "tier": "frontend"}, false},
+		{"missing label", map[string]string{"env": "prod"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesLabels(metadata, tt.selectors); got != tt.want {
+				t.Errorf("matchesLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortWorkloads(t *testing.T) {
+	named := func(name, file string) SourceWorkload {
+		return SourceWorkload{Kind: "Deployment", Workload: &Deployment{Metadata: map[string]interface{}{"name": name}}, File: file}
+	}
+	workloads := []SourceWorkload{named("orders", "a.yaml"), named("api", "z.yaml"), named("api", "b.yaml")}
+
+	sortWorkloads(workloads)
+
+	want := []string{"api b.yaml", "api z.yaml", "orders a.yaml"}
+	for i, source := range workloads {
+		if got := workloadName(source) + " " + source.File; got != want[i] {
+			t.Errorf("workloads[%d] = %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestSortContainers(t *testing.T) {
+	input := "apiVersion: apps/v1\nkind: Deployment\nspec:\n  template:\n    spec:\n      containers:\n        - name: web\n          image: nginx\n        - name: app # main\n          image: api\n"
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(input), &node); err != nil {
+		t.Fatal(err)
+	}
+	var dep Deployment
+	if err := node.Decode(&dep); err != nil {
+		t.Fatal(err)
+	}
+	source := SourceWorkload{Kind: "Deployment", Workload: &dep, Node: &node}
+
+	sortContainers(source)
+
+	containers := dep.Spec.Template.Spec.Containers
+	if containers[0].Name != "app" || containers[1].Name != "web" {
+		t.Errorf("containers = %+v, want app before web", containers)
+	}
+	out, err := marshalWorkload(source, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "- name: app # main\n          image: api\n        - name: web\n          image: nginx\n") {
+		t.Errorf("document containers are not sorted along with the workload:\n%s", out)
+	}
+}
+
+func TestSkipAnnotated(t *testing.T) {
+	workload := func(name, skip string) SourceWorkload {
+		metadata := map[string]interface{}{"name": name}
+		if skip != "" {
+			metadata["annotations"] = map[string]interface{}{"env-injector/skip": skip}
+		}
+		return SourceWorkload{Kind: "Deployment", Workload: &Deployment{Metadata: metadata}}
+	}
+	workloads := []SourceWorkload{workload("api", ""), workload("legacy", "true"), workload("worker", "false")}
+
+	var got []string
+	entries := captureJSONLogs(t, func() {
+		for _, source := range skipAnnotated(workloads, "env-injector/skip") {
+			got = append(got, workloadName(source))
+		}
+	})
+	if len(entries) != 0 {
+		t.Errorf("skipAnnotated() logged %+v without -v, want the skips at info level", entries)
+	}
+	if want := []string{"api", "worker"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("skipAnnotated() = %v, want %v", got, want)
+	}
+	if got := skipAnnotated(workloads, ""); len(got) != len(workloads) {
+		t.Errorf("skipAnnotated() with no annotation kept %d workloads, want all %d", len(got), len(workloads))
+	}
+}
+
+func TestApplyDefaultResources(t *testing.T) {
+	podSpec := &PodSpec{Containers: []Container{
+		{Name: "app", Resources: &ResourceRequirements{Requests: map[string]string{"cpu": "1"}}},
+		{Name: "sidecar"},
+	}}
+
+	applyDefaultResources(podSpec, map[string]string{"cpu": "100m", "memory": "128Mi"}, map[string]string{"memory": "256Mi"})
+
+	want := []*ResourceRequirements{
+		{Requests: map[string]string{"cpu": "1", "memory": "128Mi"}, Limits: map[string]string{"memory": "256Mi"}},
+		{Requests: map[string]string{"cpu": "100m", "memory": "128Mi"}, Limits: map[string]string{"memory": "256Mi"}},
+	}
+	for i, container := range podSpec.Containers {
+		if !reflect.DeepEqual(container.Resources, want[i]) {
+			t.Errorf("container %s resources = %+v, want %+v", container.Name, container.Resources, want[i])
+		}
+	}
+}